@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/jcmturner/goidentity/v6"
+	"github.com/pkg/errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the json api, under /api/v1, has the same rules as the html pages: users see and change the records
+// they created, admins all of them
+
+// apiMetaFields are the keys of a record that aren't fields of the form
+var apiMetaFields = map[string]bool{"id": true, "updated_ts": true, "created_user": true, "created_ts": true}
+
+// APIField describes a field of the form
+type APIField struct {
+	Name        string        `json:"name"`
+	Type        FormFieldType `json:"type"`
+	Label       string        `json:"label"`
+	Description string        `json:"description,omitempty"`
+	Required    bool          `json:"required"`
+	ReadOnly    bool          `json:"readOnly,omitempty"`
+	MaxLength   int           `json:"maxLength,omitempty"`
+	Min         string        `json:"min,omitempty"`
+	Max         string        `json:"max,omitempty"`
+	Pattern     string        `json:"pattern,omitempty"`
+	Options     []FieldOption `json:"options,omitempty"`
+	// Fields are those of each row of a group
+	Fields []APIField `json:"fields,omitempty"`
+}
+
+// APIForm describes the form and its fields
+type APIForm struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Path        string     `json:"path"`
+	IsAdmin     bool       `json:"isAdmin"`
+	Fields      []APIField `json:"fields"`
+}
+
+func apiFields(fields []*FormField) []APIField {
+	out := make([]APIField, 0, len(fields))
+	for _, field := range fields {
+		f := APIField{
+			Name:        field.Name,
+			Type:        field.FieldType,
+			Label:       field.Label,
+			Description: string(field.Description),
+			Required:    field.Required,
+			ReadOnly:    field.IsLDAPPopulated,
+			MaxLength:   field.MaxLength,
+			Min:         field.Min,
+			Max:         field.Max,
+			Options:     field.Options,
+		}
+		if field.regex != nil {
+			f.Pattern = field.regex.String()
+		}
+		if field.Group != nil {
+			f.Fields = apiFields(field.Group.Fields)
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// apiUser is formUser for the api, the error is json
+func apiUser(w http.ResponseWriter, req *http.Request, frm *Form) (string, bool) {
+	if creds := goidentity.FromHTTPRequestContext(req); creds != nil {
+		return creds.UserName(), true
+	}
+	if !frm.AllowAnonymous {
+		apiError(w, http.StatusUnauthorized, "unable to determine logged in user", nil)
+		return "", false
+	}
+	return "anonymous", true
+}
+
+// apiForm reads the form and user for a request to the api, writing an error if it can't
+func apiForm(w http.ResponseWriter, req *http.Request) (*Form, string, bool) {
+	frm, err := forms.get(req.Context(), mux.Vars(req)["table_name"])
+	if err != nil {
+		apiError(w, http.StatusNotFound, err.Error(), nil)
+		return nil, "", false
+	}
+	username, ok := apiUser(w, req, frm)
+	return frm, username, ok
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// apiError writes the error as json, with the problems with each field for invalid values
+func apiError(w http.ResponseWriter, status int, msg string, fields FieldErrors) {
+	body := map[string]interface{}{"error": msg}
+	if fields != nil {
+		body["fields"] = fields
+	}
+	writeJSON(w, status, body)
+}
+
+// apiSaveError writes the error from saving a record, with the fields of an invalid record, or the
+// current version of a record that was changed since it was read
+func apiSaveError(w http.ResponseWriter, frm *Form, err error) {
+	switch e := err.(type) {
+	case *ValidationError:
+		apiError(w, http.StatusUnprocessableEntity, e.Error(), e.Fields)
+	case *ConflictError:
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":   e.Error(),
+			"current": apiRecord(frm, e.Current, e.Rows),
+		})
+	default:
+		log.Println(err)
+		apiError(w, http.StatusInternalServerError, err.Error(), nil)
+	}
+}
+
+// apiValue is the form value as json: numbers, booleans, lists of the options of multi-selects and
+// the urls of files. Empty values are null.
+func apiValue(frm *Form, field *FormField, id string, value string) interface{} {
+	switch field.FieldType {
+	case FormBoolean:
+		return value == "1"
+	case FormMultiSelect:
+		return splitMultiValue(value)
+	case FormFile:
+		url := fmt.Sprintf("/%s/file/%s/%s", frm.Path, id, field.Name)
+		if !field.MultipleFiles() {
+			if value == "" {
+				return nil
+			}
+			return url
+		}
+		files := make([]map[string]string, 0)
+		for _, a := range field.Attachments(value) {
+			files = append(files, map[string]string{"id": a.ID, "name": a.Name, "url": url + "/" + a.ID})
+		}
+		return files
+	}
+	if value == "" {
+		return nil
+	}
+	switch field.FieldType {
+	case FormInteger, FormDecimal, FormMoney, FormFloat:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return json.Number(value)
+		}
+	}
+	return value
+}
+
+// apiRecord is the record as json, with the fields that were loaded. Rows of groups have their id.
+func apiRecord(frm *Form, vals map[string]string, rows groupRows) map[string]interface{} {
+	out := make(map[string]interface{})
+	for name := range apiMetaFields {
+		if val, exists := vals[name]; exists {
+			out[name] = val
+		}
+	}
+	out["id"] = json.Number(vals["id"])
+	for _, field := range frm.Fields {
+		if field.Group != nil {
+			groupRows, exists := rows[field.Name]
+			if !exists {
+				continue
+			}
+			outRows := make([]map[string]interface{}, 0, len(groupRows))
+			for _, row := range groupRows {
+				outRow := map[string]interface{}{"id": json.Number(row["id"])}
+				for _, child := range field.Group.Fields {
+					outRow[child.Name] = apiValue(frm, child, vals["id"], row[child.Name])
+				}
+				outRows = append(outRows, outRow)
+			}
+			out[field.Name] = outRows
+			continue
+		}
+		if val, exists := vals[field.Name]; exists {
+			out[field.Name] = apiValue(frm, field, vals["id"], val)
+		}
+	}
+	return out
+}
+
+// apiFormValue converts a json value to the field's form value. Timestamps can be RFC 3339, which are
+// converted to the time at the offset, or in the form's format in the offset's timezone.
+func apiFormValue(field *FormField, v interface{}, offset *time.Location) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "", nil
+	case bool:
+		if val {
+			return "1", nil
+		}
+		return "", nil
+	case json.Number:
+		return val.String(), nil
+	case string:
+		if field.FieldType == FormTimeStamp {
+			if t, err := time.Parse(time.RFC3339, val); err == nil {
+				return t.In(offset).Format(DateTimeLocal), nil
+			}
+		}
+		return val, nil
+	case []interface{}:
+		if field.FieldType != FormMultiSelect {
+			break
+		}
+		selected := make([]string, 0, len(val))
+		for _, item := range val {
+			s, err := apiFormValue(field, item, offset)
+			if err != nil {
+				return "", err
+			}
+			selected = append(selected, s)
+		}
+		return strings.Join(selected, multiValueSeparator), nil
+	}
+	return "", errors.Errorf("%s has a value of the wrong type", field.Name)
+}
+
+// apiSubmission applies the fields in the json body to the values and rows of the record. Fields
+// that aren't in the body are left as they are.
+func apiSubmission(frm *Form, req *http.Request, vals map[string]string, rows groupRows) error {
+	decoder := json.NewDecoder(req.Body)
+	decoder.UseNumber()
+	body := make(map[string]interface{})
+	if err := decoder.Decode(&body); err != nil {
+		return errors.Wrap(err, "invalid json")
+	}
+
+	// timestamps without an offset are in the server's timezone
+	_, offsetSecs := time.Now().Zone()
+	offset := time.FixedZone("", offsetSecs)
+	vals["timezone-offset"] = strconv.Itoa(-offsetSecs / 60)
+	if token, ok := body["updated_ts"].(string); ok {
+		vals["updated_ts"] = token
+	}
+
+	fields := make(map[string]*FormField)
+	for _, field := range frm.Fields {
+		fields[field.Name] = field
+	}
+	for name, v := range body {
+		field, exists := fields[name]
+		if !exists {
+			if apiMetaFields[name] {
+				continue
+			}
+			return errors.Errorf("%s is not a field of the form", name)
+		}
+		switch {
+		case field.FieldType == FormFile:
+			return errors.Errorf("%s is a file, files can't be uploaded with the api", name)
+		case field.Group != nil:
+			items, ok := v.([]interface{})
+			if !ok {
+				return errors.Errorf("%s must be a list of rows", name)
+			}
+			groupRows := make([]map[string]string, 0, len(items))
+			for _, item := range items {
+				obj, ok := item.(map[string]interface{})
+				if !ok {
+					return errors.Errorf("the rows of %s must be objects", name)
+				}
+				row := map[string]string{"id": ""}
+				if id, exists := obj["id"]; exists && id != nil {
+					row["id"] = fmt.Sprint(id)
+				}
+				for _, child := range field.Group.Fields {
+					val, err := apiFormValue(child, obj[child.Name], offset)
+					if err != nil {
+						return err
+					}
+					row[child.Name] = val
+				}
+				groupRows = append(groupRows, row)
+			}
+			rows[name] = groupRows
+		default:
+			val, err := apiFormValue(field, v, offset)
+			if err != nil {
+				return err
+			}
+			vals[name] = val
+		}
+	}
+	return nil
+}
+
+// apiSave validates and saves the record from the api
+func apiSave(ctx context.Context, username string, frm *Form, vals map[string]string, rows groupRows) (int, error) {
+	parsed, err := validateSubmission(ctx, frm, vals, rows)
+	if err != nil {
+		return 0, err
+	}
+	return commitSubmission(ctx, username, frm, vals, parsed, map[string]*fileChange{})
+}
+
+// ServeAPIForm describes the form and its fields
+func ServeAPIForm(w http.ResponseWriter, req *http.Request) {
+	frm, username, ok := apiForm(w, req)
+	if !ok {
+		return
+	}
+	isAdmin, _ := frm.Admins[username]
+	writeJSON(w, http.StatusOK, APIForm{
+		Name:        frm.Name,
+		Description: frm.Description,
+		Path:        frm.Path,
+		IsAdmin:     isAdmin,
+		Fields:      apiFields(frm.Fields),
+	})
+}
+
+// ServeAPIRecords lists a page of the records the user can see, with the same query parameters as the
+// list page, or creates a record
+func ServeAPIRecords(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	frm, username, ok := apiForm(w, req)
+	if !ok {
+		return
+	}
+
+	if req.Method == http.MethodPost {
+		vals, rows := map[string]string{"id": ""}, groupRows{}
+		if err := apiSubmission(frm, req, vals, rows); err != nil {
+			apiError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		vals["id"] = ""
+		id, err := apiSave(ctx, username, frm, vals, rows)
+		if err != nil {
+			apiSaveError(w, frm, err)
+			return
+		}
+		vals, rows, err = loadFormEntry(ctx, username, id, frm)
+		if err != nil {
+			apiSaveError(w, frm, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/forms/%s/records/%d", frm.Path, id))
+		writeJSON(w, http.StatusCreated, apiRecord(frm, vals, rows))
+		return
+	}
+
+	list := parseListQuery(frm, req)
+	vals, err := loadFormList(ctx, username, frm, list, false)
+	if err != nil {
+		log.Println(err)
+		apiError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	records := make([]map[string]interface{}, 0, len(vals))
+	for _, row := range vals {
+		records = append(records, apiRecord(frm, row, nil))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"records": records,
+		"page":    list.Page,
+		"pages":   list.Pages(),
+		"total":   list.Total,
+	})
+}
+
+// ServeAPIRecord reads, updates or deletes a record. An update only changes the fields given, and
+// is rejected if the record was changed since it was read when updated_ts is given.
+func ServeAPIRecord(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	frm, username, ok := apiForm(w, req)
+	if !ok {
+		return
+	}
+	id, _ := strconv.Atoi(mux.Vars(req)["id"])
+	allowed, err := canAccessRecord(ctx, username, frm, id)
+	if err != nil {
+		log.Println(err)
+		apiError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	if !allowed {
+		apiError(w, http.StatusNotFound, "Unable to find record", nil)
+		return
+	}
+
+	if req.Method == http.MethodDelete {
+		if err := deleteFormEntry(ctx, username, frm, id); err != nil {
+			log.Println(err)
+			apiError(w, deleteStatus(err), err.Error(), nil)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	vals, rows, err := loadFormEntry(ctx, username, id, frm)
+	if err != nil {
+		log.Println(err)
+		apiError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	if req.Method == http.MethodPut {
+		if err := apiSubmission(frm, req, vals, rows); err != nil {
+			apiError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		vals["id"] = strconv.Itoa(id)
+		if _, err := apiSave(ctx, username, frm, vals, rows); err != nil {
+			apiSaveError(w, frm, err)
+			return
+		}
+		if vals, rows, err = loadFormEntry(ctx, username, id, frm); err != nil {
+			apiSaveError(w, frm, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, apiRecord(frm, vals, rows))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// auditTable records every insert and update of the forms, saving is only audited if it exists
+const auditTable = "form_audit"
+
+// AuditChange is a field that was changed by a save, with its values as they were shown to the user
+type AuditChange struct {
+	Field  string `json:"field"`
+	Label  string `json:"-"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// AuditEntry is a save of a record, from the audit table
+type AuditEntry struct {
+	Changed  time.Time
+	Username string
+	Action   string
+	Changes  []AuditChange
+}
+
+// auditValue is the field's value as shown to the user, for recording in the audit table
+func auditValue(field *FormField, vals map[string]string, rows groupRows) string {
+	switch {
+	case field.Group != nil:
+		lines := make([]string, 0)
+		for _, row := range rows[field.Name] {
+			cells := make([]string, 0, len(field.Group.Fields))
+			for _, child := range field.Group.Fields {
+				cells = append(cells, child.Label+": "+child.OptionLabel(row[child.Name]))
+			}
+			lines = append(lines, strings.Join(cells, ", "))
+		}
+		return strings.Join(lines, "\n")
+	case field.FieldType == FormFile:
+		names := make([]string, 0)
+		for _, a := range field.Attachments(vals[field.Name]) {
+			names = append(names, a.Name)
+		}
+		return strings.Join(names, ", ")
+	}
+	return field.OptionLabel(vals[field.Name])
+}
+
+// auditChanges compares the record before and after it was saved, with the files that were uploaded
+// to columns
+func auditChanges(frm *Form, before map[string]string, beforeRows groupRows, after map[string]string, afterRows groupRows, files map[string]*fileChange) []AuditChange {
+	changes := make([]AuditChange, 0)
+	for _, field := range frm.Fields {
+		b := auditValue(field, before, beforeRows)
+		a := auditValue(field, after, afterRows)
+		if field.FieldType == FormFile && field.isColumn() && files[field.Name].changed() {
+			a = files[field.Name].auditValue()
+		}
+		if a != b {
+			changes = append(changes, AuditChange{Field: field.Name, Before: b, After: a})
+		}
+	}
+	return changes
+}
+
+// recordAudit saves the changes to the record in the audit table, if the database has one
+func recordAudit(ctx context.Context, q queryer, frm *Form, id int, username string, action string, changes []AuditChange) error {
+	if !frm.audited || len(changes) == 0 {
+		return nil
+	}
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode audit changes")
+	}
+	query := fmt.Sprintf(
+		"INSERT INTO %s (form_path, record_id, username, changed_ts, action, changes) VALUES (%s, %s, %s, CURRENT_TIMESTAMP, %s, %s)",
+		auditTable,
+		dialect.Placeholder(1),
+		dialect.Placeholder(2),
+		dialect.Placeholder(3),
+		dialect.Placeholder(4),
+		dialect.Placeholder(5))
+	if _, err := q.ExecContext(ctx, query, frm.Path, id, username, action, string(encoded)); err != nil {
+		return errors.Wrap(err, "unable to record audit history")
+	}
+	return nil
+}
+
+// loadAuditHistory reads the saves of the record from the audit table, most recent first
+func loadAuditHistory(ctx context.Context, frm *Form, id int) ([]AuditEntry, error) {
+	if !frm.audited {
+		return nil, nil
+	}
+	query := fmt.Sprintf(
+		"SELECT username, changed_ts, action, changes FROM %s WHERE form_path = %s AND record_id = %s ORDER BY id DESC",
+		auditTable,
+		dialect.Placeholder(1),
+		dialect.Placeholder(2))
+	rows, err := db.QueryContext(ctx, query, frm.Path, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query audit history")
+	}
+	labels := make(map[string]string)
+	for _, field := range frm.Fields {
+		labels[field.Name] = field.Label
+	}
+	out := make([]AuditEntry, 0)
+	for rows.Next() {
+		entry := AuditEntry{}
+		var changes string
+		if err := rows.Scan(&entry.Username, &entry.Changed, &entry.Action, &changes); err != nil {
+			_ = rows.Close()
+			return nil, errors.Wrap(err, "unable to read audit history")
+		}
+		if err := json.Unmarshal([]byte(changes), &entry.Changes); err != nil {
+			_ = rows.Close()
+			return nil, errors.Wrap(err, "unable to decode audit changes")
+		}
+		for i, change := range entry.Changes {
+			// the field may have since been removed from the form
+			entry.Changes[i].Label = change.Field
+			if label, exists := labels[change.Field]; exists {
+				entry.Changes[i].Label = label
+			}
+		}
+		out = append(out, entry)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, errors.Wrap(err, "unable to close audit rows")
+	}
+	return out, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// formCache holds the built forms keyed by path, so we don't need to introspect the table and
+// read the labels on every request
+type formCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	forms map[string]*cachedForm
+}
+
+type cachedForm struct {
+	form   *Form
+	loaded time.Time
+}
+
+// forms is the cache used by the handlers, a zero ttl disables caching
+var forms = newFormCache(0)
+
+func newFormCache(ttl time.Duration) *formCache {
+	return &formCache{
+		ttl:   ttl,
+		forms: make(map[string]*cachedForm),
+	}
+}
+
+// get returns the form at the path, loading it if it isn't cached or has expired.
+// The returned form is a copy, so the per-request fields can be set on it.
+func (c *formCache) get(ctx context.Context, formPath string) (*Form, error) {
+	if c.ttl <= 0 {
+		return loadForm(ctx, formPath)
+	}
+
+	c.mu.Lock()
+	cached, exists := c.forms[formPath]
+	c.mu.Unlock()
+	if exists && time.Since(cached.loaded) < c.ttl {
+		frm := *cached.form
+		return &frm, nil
+	}
+
+	frm, err := loadForm(ctx, formPath)
+	if err != nil {
+		return nil, err
+	}
+	c.put(frm)
+	cp := *frm
+	return &cp, nil
+}
+
+// put replaces the cached copy of the form
+func (c *formCache) put(frm *Form) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.forms[frm.Path] = &cachedForm{form: frm, loaded: time.Now()}
+}
+
+// invalidate drops any cached forms that use the given path, table or labels table.
+// An empty name drops everything.
+func (c *formCache) invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name == "" {
+		c.forms = make(map[string]*cachedForm)
+		return
+	}
+	for path, cached := range c.forms {
+		if path == name || matchesTable(cached.form.TableName, name) || matchesTable(cached.form.TableName+"_labels", name) {
+			log.Printf("invalidating cached form %s", path)
+			delete(c.forms, path)
+		}
+	}
+}
+
+// matchesTable compares table names, a schema qualified name matches an unqualified table of the same name
+func matchesTable(tableName string, name string) bool {
+	if tableName == name {
+		return true
+	}
+	if !strings.Contains(tableName, ".") {
+		_, table := splitTableName(name)
+		return table == tableName
+	}
+	return false
+}
+
+// setupFormCache sets the cache lifetime from the config, and listens for changes if the database supports it
+func setupFormCache(conf tomlConfig) {
+	ttl := time.Duration(conf.Database.FormCacheSeconds) * time.Second
+	forms = newFormCache(ttl)
+	if ttl <= 0 {
+		return
+	}
+	if l, ok := dialect.(formChangeListener); ok {
+		if err := l.ListenForFormChanges(conf.Database.ConnectionString, forms.invalidate); err != nil {
+			log.Printf("form changes will only be picked up after %s: %s", ttl, err)
+		}
+	}
+}
+
+// formChangeListener is implemented by dialects that can tell us when a form definition has changed
+type formChangeListener interface {
+	// ListenForFormChanges calls invalidate with the path or table name of each changed form
+	ListenForFormChanges(connectionString string, invalidate func(name string)) error
+}
@@ -11,6 +11,7 @@ type tomlConfig struct {
 	LDAP     ldapConfig
 	Database databaseConfig
 	Auth     authConfig
+	Files    filesConfig
 }
 
 type ldapConfig struct {
@@ -30,12 +31,26 @@ type serverConfig struct {
 type databaseConfig struct {
 	DbType           DBType
 	ConnectionString string
+	FormCacheSeconds int
+}
+
+type filesConfig struct {
+	MaxSizeMB    int
+	MaxRequestMB int
+	AllowedTypes []string
 }
 
 type authConfig struct {
 	Keytab     string
 	CookieName string
 	SessionKey string
+	// OpenID Connect login, instead of the keytab
+	OIDCIssuer        string
+	OIDCClientID      string
+	OIDCClientSecret  string
+	OIDCRedirectURL   string
+	OIDCScopes        []string
+	OIDCUsernameClaim string
 }
 
 func parseConfig(confFile string) tomlConfig {
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+var conflictTemplate *template.Template
+
+// ConflictError is returned when the record was changed by someone else after it was loaded for
+// editing, it holds the record as it is now
+type ConflictError struct {
+	Current map[string]string
+	Rows    groupRows
+}
+
+func (e *ConflictError) Error() string {
+	return "the record has been changed since it was loaded"
+}
+
+// ConflictField is a field of the record as the user submitted it, and as it now is
+type ConflictField struct {
+	Label   string
+	Yours   string
+	Current string
+}
+
+// Differs reports whether the user's value is not the current one
+func (f ConflictField) Differs() bool {
+	return f.Yours != f.Current
+}
+
+// updateFailure explains why an update of the record affected no rows: either it was changed since
+// the user loaded it, or they can't find it
+func updateFailure(ctx context.Context, q queryer, username string, frm *Form, vals map[string]string) error {
+	id, err := strconv.Atoi(vals["id"])
+	if err != nil {
+		return errors.Wrap(err, "invalid id")
+	}
+	current, rows, err := loadEntry(ctx, q, username, id, frm)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return errors.New("Unable to find record")
+		}
+		return errors.Wrap(err, "unable to load the record")
+	}
+	if current["updated_ts"] == vals["updated_ts"] {
+		return errors.New("Unable to find record")
+	}
+	return &ConflictError{Current: current, Rows: rows}
+}
+
+// conflictFields compares the user's submission with the current record. Files aren't resubmitted
+// with the form, so are left out.
+func conflictFields(frm *Form, vals map[string]string, rows groupRows, conflict *ConflictError) []ConflictField {
+	out := make([]ConflictField, 0, len(frm.Fields))
+	for _, field := range frm.Fields {
+		if field.FieldType == FormFile {
+			continue
+		}
+		out = append(out, ConflictField{
+			Label:   field.Label,
+			Yours:   auditValue(field, vals, rows),
+			Current: auditValue(field, conflict.Current, conflict.Rows),
+		})
+	}
+	return out
+}
+
+// renderConflict shows the user their submission alongside the record as it is now, so they can
+// reapply their changes to the latest version
+func renderConflict(w http.ResponseWriter, frm *Form, vals map[string]string, rows groupRows, conflict *ConflictError, username string) {
+	buf := new(bytes.Buffer)
+	err := conflictTemplate.Execute(buf, map[string]interface{}{
+		"frm": frm, "id": vals["id"], "fields": conflictFields(frm, vals, rows, conflict), "username": username,
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusConflict)
+	_, _ = buf.WriteTo(w)
+}
@@ -10,8 +10,11 @@ import (
 	"github.com/pkg/errors"
 	"html/template"
 	"log"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type DBType string
@@ -19,139 +22,268 @@ type DBType string
 const (
 	DbSqlServer DBType = "sqlserver"
 	DbPostgres         = "postgres"
+	DbMySQL            = "mysql"
+	DbSQLite           = "sqlite"
 )
 
 type dbCol struct {
-	name    string
-	colType string
-	notNull bool
+	name      string
+	colType   string
+	notNull   bool
+	maxLength int
 }
 
 var db *sql.DB
 var dbType DBType
 
 func loadTableDBCols(ctx context.Context, tableName string) ([]*dbCol, error) {
+	schema, table := splitTableName(tableName)
+	// we skip the top 4 rows to just get to the content
+	return dialect.TableColumns(ctx, schema, table, 4)
+}
 
-	query := `
-		SELECT f.attname,
-			   pg_catalog.format_type(f.atttypid, f.atttypmod),
-       		   f.attnotnull
-		FROM
-			pg_attribute f
-			JOIN pg_class c ON c.oid = f.attrelid
-			LEFT JOIN pg_namespace n ON n.oid = c.relnamespace
-		WHERE c.relkind = 'r'::char
-		  AND n.nspname = 'public'
-		  AND c.relname = $1
-		  AND f.attnum > $2
-		ORDER BY f.attnum
-		`
-	if dbType == DbSqlServer {
-		query = `
-			SELECT COLUMN_NAME,
-				   DATA_TYPE,
-				   IIF(IS_NULLABLE = 'NO', 1, 0)
-			FROM information_schema.columns
-			WHERE table_name = @p1
-			  AND ORDINAL_POSITION > @p2
-			ORDER BY ordinal_position;
-			`
-	}
-
-	// we skip the top 4 rows to just get to the content (f.attnum > 4)
-	rows, err := db.QueryContext(ctx, query, tableName, 4)
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to query table metadata")
-	}
+// fieldLabels is a row from the form's _labels table
+type fieldLabels struct {
+	field            FormField
+	options          string
+	optionsAsRadio   bool
+	junctionTable    string
+	childTable       string
+	attachmentsTable string
+}
 
-	cols := make([]*dbCol, 0)
-	for rows.Next() {
-		col := dbCol{}
-		err = rows.Scan(&col.name, &col.colType, &col.notNull)
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to read table column metadata")
-		}
-		cols = append(cols, &col)
-	}
-	if closeErr := rows.Close(); closeErr != nil {
-		return nil, errors.Wrap(err, "unable to close column metadata rows")
-	}
-	return cols, nil
+// hasOwnTable is true for fields saved in another table rather than a column of the form's table
+func (l *fieldLabels) hasOwnTable() bool {
+	return l.junctionTable != "" || l.childTable != "" || l.attachmentsTable != ""
 }
 
-func loadField(ctx context.Context, col *dbCol, tableName string) (*FormField, error) {
-	fieldType := dataTypeToFieldType(col.colType)
+// optionalLabelColumn is a _labels column that was added after the table layout was first published,
+// it's only read if the table has it.
+type optionalLabelColumn struct {
+	name string
+	set  func(l *fieldLabels, val string)
+}
+
+var optionalLabelColumns = []optionalLabelColumn{
+	{"options_params", func(l *fieldLabels, val string) { l.field.optionsParams = val }},
+	{"display_column", func(l *fieldLabels, val string) { l.field.displayColumn = val }},
+	{"min_value", func(l *fieldLabels, val string) { l.field.Min = val }},
+	{"max_value", func(l *fieldLabels, val string) { l.field.Max = val }},
+	{"multi_select", func(l *fieldLabels, val string) { l.field.multiSelect, _ = strconv.ParseBool(val) }},
+	{"junction_table", func(l *fieldLabels, val string) { l.junctionTable = val }},
+	{"child_table", func(l *fieldLabels, val string) { l.childTable = val }},
+	{"attachments_table", func(l *fieldLabels, val string) { l.attachmentsTable = val }},
+}
 
-	field := &FormField{
-		Name:      col.name,
-		FieldType: fieldType,
-		Required:  col.notNull,
+// loadOptionalLabelColumns returns the optional columns the labels table has
+func loadOptionalLabelColumns(ctx context.Context, labelsTable string) ([]optionalLabelColumn, error) {
+	schema, table := splitTableName(labelsTable)
+	cols, err := dialect.TableColumns(ctx, schema, table, 0)
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool)
+	for _, col := range cols {
+		present[col.name] = true
 	}
+	out := make([]optionalLabelColumn, 0)
+	for _, opt := range optionalLabelColumns {
+		if present[opt.name] {
+			out = append(out, opt)
+		}
+	}
+	return out, nil
+}
 
+// loadFieldLabels reads all of the label metadata for the table in one go, keyed by column name
+func loadFieldLabels(ctx context.Context, tableName string) (map[string]*fieldLabels, error) {
 	labelsTable := tableName + "_labels"
+	optional, err := loadOptionalLabelColumns(ctx, labelsTable)
+	if err != nil {
+		return nil, err
+	}
+	optionalCols := ""
+	for _, opt := range optional {
+		optionalCols += ", " + dialect.QuoteIdent(opt.name)
+	}
 	query := `
 		SELECT
+			column_name,
 			label,
 			description,
 			placeholder,
 			options,
 			options_as_radio,
+			regex,
 			section_heading,
 			linebreak_after,
-			include_in_summary
-		FROM ` + labelsTable + " WHERE column_name = $1"
-	if dbType == DbSqlServer {
-		query = strings.ReplaceAll(query, "$1", "@p1")
+			include_in_summary` + optionalCols + `
+		FROM ` + quoteTable(labelsTable)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("query error, does the table %s exist?", labelsTable))
+	}
+
+	labels := make(map[string]*fieldLabels)
+	for rows.Next() {
+		l := fieldLabels{}
+		optionalVals := make([]sql.NullString, len(optional))
+		dest := []interface{}{
+			&l.field.Name,
+			&l.field.Label,
+			&l.field.Description,
+			&l.field.Placeholder,
+			&l.options,
+			&l.optionsAsRadio,
+			&l.field.Regex,
+			&l.field.SectionHeading,
+			&l.field.LinebreakAfter,
+			&l.field.IncludeInSummary,
+		}
+		for i := range optionalVals {
+			dest = append(dest, &optionalVals[i])
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			_ = rows.Close()
+			return nil, errors.Wrap(err, fmt.Sprintf("unable to read labels from %s", labelsTable))
+		}
+		for i, opt := range optional {
+			opt.set(&l, optionalVals[i].String)
+		}
+		labels[l.field.Name] = &l
 	}
+	if err := rows.Close(); err != nil {
+		return nil, errors.Wrap(err, "unable to close label rows")
+	}
+	return labels, nil
+}
+
+func loadField(col *dbCol, labels map[string]*fieldLabels) *FormField {
+	fieldType := dialect.FieldType(col.colType)
+
+	field := &FormField{}
 	options := ""
 	optionsAsRadio := false
-	err :=
-		db.
-			QueryRowContext(ctx, query, col.name).
-			Scan(
-				&field.Label,
-				&field.Description,
-				&field.Placeholder,
-				&options,
-				&optionsAsRadio,
-				&field.SectionHeading,
-				&field.LinebreakAfter,
-				&field.IncludeInSummary)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			// we had no label metadata for this field, that's cool, just give it something default
-			field.Label = strings.ReplaceAll(col.name, "_", " ")
-			if len(field.Label) > 0 {
-				field.Label = strings.ToUpper(field.Label[0:1]) + field.Label[1:]
-			}
-		} else {
-			return nil, errors.Wrap(err, fmt.Sprintf("query error, does the table %s exist?", labelsTable))
+	if l, exists := labels[col.name]; exists {
+		*field = l.field
+		options = l.options
+		optionsAsRadio = l.optionsAsRadio
+	} else {
+		// we had no label metadata for this field, that's cool, just give it something default
+		field.Label = strings.ReplaceAll(col.name, "_", " ")
+		if len(field.Label) > 0 {
+			field.Label = strings.ToUpper(field.Label[0:1]) + field.Label[1:]
 		}
 	}
+	field.Name = col.name
+	field.FieldType = fieldType
+	field.Required = col.notNull
+	field.MaxLength = col.maxLength
+	field.optionsAsRadio = optionsAsRadio
+	if fieldType == FormMultiSelect {
+		field.multiStorage = arrayStorage
+	}
 
 	if options != "" {
-		if optionsAsRadio {
+		switch {
+		case fieldType == FormMultiSelect || field.multiSelect:
+			field.FieldType = FormMultiSelect
+		case optionsAsRadio:
 			field.FieldType = FormRadio
-		} else {
+		default:
 			field.FieldType = FormSelect
 		}
-		field.Options = strings.Split(options, ",")
+		// these are resolved in loadForm, they may need to query the database
+		field.optionsSpec = options
+	}
+
+	// files aren't shown in the list of submissions
+	if field.FieldType == FormFile {
+		field.IncludeInSummary = false
 	}
 
 	if field.Description != "" {
 		field.Description = template.HTML(markdown.ToHTML([]byte(field.Description), nil, nil))
 	}
 
-	return field, nil
+	return field
+}
+
+// resolveField compiles the field's regex and loads its options, either from its labels or the
+// table referenced by its foreign key
+func resolveField(ctx context.Context, field *FormField, fk *foreignKey, problem func(error)) {
+	if err := compileFieldRegex(field); err != nil {
+		problem(err)
+	}
+	if field.optionsSpec != "" {
+		if err := loadFieldOptions(ctx, field); err != nil {
+			problem(err)
+		}
+	} else if fk != nil {
+		if err := applyForeignKey(ctx, field, fk); err != nil {
+			problem(err)
+		}
+	}
+}
+
+// loadFieldOptions resolves the options set in the field's labels
+func loadFieldOptions(ctx context.Context, field *FormField) error {
+	if field.optionsSpec == "" {
+		return nil
+	}
+	var err error
+	field.Options, err = resolveOptions(ctx, field.optionsSpec, field.optionsParams)
+	if err != nil {
+		// without its options every value would be rejected, so it's filled in as text until they can
+		// be read
+		if field.FieldType == FormSelect || field.FieldType == FormRadio {
+			field.FieldType = FormVarChar
+		}
+		return errors.Wrap(err, fmt.Sprintf("unable to load the options for %s", field.Name))
+	}
+	return nil
+}
+
+// parseAdmins reads the comma-separated usernames of a form's admins
+func parseAdmins(admins string) map[string]bool {
+	out := make(map[string]bool)
+	for _, f := range strings.Split(admins, ",") {
+		out[strings.TrimSpace(f)] = true
+	}
+	return out
+}
+
+// loadFormCatalogue reads the name, description, path and who can use each of the forms, ordered by
+// name, without building them
+func loadFormCatalogue(ctx context.Context) ([]*Form, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT name, description, path, table_name, admins, allow_anonymous FROM forms ORDER BY name")
+	if err != nil {
+		return nil, errors.Wrap(err, "loadFormCatalogue query error")
+	}
+	out := make([]*Form, 0)
+	for rows.Next() {
+		form := &Form{}
+		admins := ""
+		if err := rows.Scan(&form.Name, &form.Description, &form.Path, &form.TableName, &admins, &form.AllowAnonymous); err != nil {
+			_ = rows.Close()
+			return nil, errors.Wrap(err, "loadFormCatalogue scan error")
+		}
+		form.Admins = parseAdmins(admins)
+		out = append(out, form)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, errors.Wrap(err, "unable to close forms rows")
+	}
+	return out, nil
 }
 
 func loadForm(ctx context.Context, formPath string) (*Form, error) {
 	// let's get the other details for the form
-	form := new(Form)
-	query := "SELECT name, description, table_name, admins, allow_anonymous, use_ldap_fields FROM forms WHERE path = $1"
-	if dbType == DbSqlServer {
-		query = "SELECT name, description, table_name, admins, allow_anonymous, use_ldap_fields FROM forms WHERE path = @p1"
-	}
+	form := &Form{Path: formPath}
+	query := "SELECT name, description, table_name, admins, allow_anonymous, use_ldap_fields FROM forms WHERE path = " +
+		dialect.Placeholder(1)
 	admins := ""
 	err :=
 		db.
@@ -163,11 +295,16 @@ func loadForm(ctx context.Context, formPath string) (*Form, error) {
 		}
 		return nil, errors.Wrap(err, "loadForm query error")
 	}
-	form.Admins = make(map[string]bool)
-	for _, f := range strings.Split(admins, ",") {
-		form.Admins[strings.TrimSpace(f)] = true
+	if form.audited, err = tableExists(ctx, auditTable); err != nil {
+		log.Printf("form %s: %s", formPath, err)
+	}
+	schema, table := splitTableName(form.TableName)
+	if form.fullText, err = dialect.FullTextSearch(ctx, schema, table); err != nil {
+		log.Printf("form %s: %s", formPath, err)
 	}
 
+	form.Admins = parseAdmins(admins)
+
 	dbCols, err := loadTableDBCols(ctx, form.TableName)
 	if err != nil {
 		return nil, errors.Wrap(err, "query error")
@@ -177,20 +314,116 @@ func loadForm(ctx context.Context, formPath string) (*Form, error) {
 		form.UseLDAPFields = false
 	}
 
+	labels, err := loadFieldLabels(ctx, form.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	foreignKeys := make(map[string]*foreignKey)
+	fks, err := dialect.ForeignKeys(ctx, schema, table)
+	if err != nil {
+		log.Printf("form %s: %s", formPath, err)
+		form.Problems = append(form.Problems, err.Error())
+	}
+	for _, fk := range fks {
+		foreignKeys[fk.column] = fk
+	}
+
+	problem := func(err error) {
+		log.Printf("form %s: %s", formPath, err)
+		form.Problems = append(form.Problems, err.Error())
+	}
+
 	fields := make([]*FormField, 0, len(dbCols))
+	isCol := make(map[string]bool)
 	for _, col := range dbCols {
-		field, err := loadField(ctx, col, form.TableName)
-		if err != nil {
-			return nil, err
+		isCol[col.name] = true
+		if col.name == "deleted_ts" {
+			form.SoftDelete = true
+			continue
 		}
+		field := loadField(col, labels)
 		field.IsLDAPPopulated = form.UseLDAPFields && isLDAPField(field.Name)
+		if l, exists := labels[col.name]; exists && l.hasOwnTable() {
+			problem(errors.Errorf("%s has a junction, child or attachments table but is a column of %s", col.name, form.TableName))
+		}
+		fk := foreignKeys[field.Name]
+		if field.IsLDAPPopulated {
+			fk = nil
+		}
+		resolveField(ctx, field, fk, problem)
 		fields = append(fields, field)
 	}
+
+	// multi-selects saved in a junction table, groups saved in a child table and files saved in an
+	// attachments table have no column of their own, they go after the columns
+	virtualNames := make([]string, 0)
+	for name, l := range labels {
+		if l.hasOwnTable() && !isCol[name] {
+			virtualNames = append(virtualNames, name)
+		}
+	}
+	sort.Strings(virtualNames)
+	for _, name := range virtualNames {
+		field := loadField(&dbCol{name: name}, labels)
+		if spec := labels[name].childTable; spec != "" {
+			field.FieldType = FormGroup
+			field.IncludeInSummary = false
+			if field.Group, err = loadFieldGroup(ctx, spec, problem); err != nil {
+				problem(errors.Wrap(err, fmt.Sprintf("unable to load the group %s", name)))
+				continue
+			}
+			fields = append(fields, field)
+			continue
+		}
+		if spec := labels[name].attachmentsTable; spec != "" {
+			field.FieldType = FormFile
+			field.IncludeInSummary = false
+			if field.attachments, err = parseAttachmentsTable(spec); err != nil {
+				problem(err)
+				continue
+			}
+			fields = append(fields, field)
+			continue
+		}
+		field.FieldType = FormMultiSelect
+		field.multiStorage = junctionStorage
+		if field.junction, err = parseJunctionTable(labels[name].junctionTable); err != nil {
+			problem(err)
+			continue
+		}
+		if err := loadFieldOptions(ctx, field); err != nil {
+			problem(err)
+		}
+		fields = append(fields, field)
+	}
+
+	for _, field := range fields {
+		if field.FieldType == FormMultiSelect && len(field.Options) == 0 {
+			problem(errors.Errorf("%s is a multi-select with no options", field.Name))
+		}
+	}
 	form.Fields = fields
 
 	return form, nil
 }
 
+// compileFieldRegex checks the field's regex can be used on the server, the same way the browser uses
+// the pattern attribute (matching the whole value). A regex that doesn't compile is dropped, rather
+// than stopping anyone using the form.
+func compileFieldRegex(field *FormField) error {
+	if field.Regex == "" {
+		return nil
+	}
+	re, err := regexp.Compile("^(?:" + string(field.Regex) + ")$")
+	if err != nil {
+		field.Regex = ""
+		return errors.Wrap(err, fmt.Sprintf("the regex for %s is invalid and is being ignored", field.Name))
+	}
+	field.regex = re
+	return nil
+}
+
 func isLDAPField(fieldName string) bool {
 	return fieldName == "user_employee_number" ||
 		fieldName == "user_display_name" ||
@@ -205,9 +438,52 @@ func isLDAPField(fieldName string) bool {
 		fieldName == "manager_location"
 }
 
-func loadFormList(ctx context.Context, user string, frm *Form) ([]map[string]string, error) {
+// loadFormList reads a page of the records the user can see, sorted and filtered as given by list,
+// and sets the total number of matching records in list. The records have the fields in the summary,
+// or all of those in the table's columns or a junction table if summaryOnly isn't set.
+func loadFormList(ctx context.Context, user string, frm *Form, list *ListQuery, summaryOnly bool) ([]map[string]string, error) {
+	where, args := listWhere(user, frm, list)
+
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s%s", quoteTable(frm.TableName), where)
+	if err := db.QueryRowContext(ctx, countQuery, args...).Scan(&list.Total); err != nil {
+		return nil, errors.Wrap(err, "loadFormList count error")
+	}
+
+	return loadRecords(ctx, frm, summaryOnly, where, args, list.orderBy(), listPageSize, (list.Page-1)*listPageSize)
+}
 
-	cols := make([]string, 0, len(frm.Fields))
+// listWhere is the WHERE clause for the records the user can see (all of them for admins) that
+// match the filters of the list, with its args
+func listWhere(user string, frm *Form, list *ListQuery) (string, []interface{}) {
+	isAdmin, _ := frm.Admins[user]
+	conds := make([]string, 0)
+	args := make([]interface{}, 0)
+	if !isAdmin {
+		conds = append(conds, "created_user = "+dialect.Placeholder(1))
+		args = append(args, user)
+	}
+	if frm.SoftDelete {
+		conds = append(conds, "deleted_ts IS NULL")
+	}
+	conds, args = list.where(conds, args)
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// loadRecords reads a page of the records selected by the WHERE clause, with the fields in the summary
+// or all of the fields stored in the table's columns or a junction table
+func loadRecords(ctx context.Context, frm *Form, summaryOnly bool, where string, args []interface{}, orderBy string, limit int, offset int) ([]map[string]string, error) {
+
+	fields := make([]*FormField, 0, len(frm.Fields))
+	for _, fld := range frm.Fields {
+		if fld.isColumn() && (fld.IncludeInSummary || !summaryOnly) {
+			fields = append(fields, fld)
+		}
+	}
+
+	cols := make([]string, 0, len(fields)+3)
 	vals := make([]interface{}, 0, len(cols))
 	// add the id for the first col
 	cols = append(cols, "id")
@@ -222,30 +498,20 @@ func loadFormList(ctx context.Context, user string, frm *Form) ([]map[string]str
 	var valTs interface{} = ""
 	vals = append(vals, &valTs)
 	// add the rest
-	for _, fld := range frm.Fields {
-		if fld.IncludeInSummary {
-			cols = append(cols, fld.Name)
-			val := emptyFormVal(fld.FieldType)
-			vals = append(vals, &val)
-		}
-	}
-
-	isAdmin, _ := frm.Admins[user]
-	var rows *sql.Rows
-	var err error
-	if isAdmin {
-		query := fmt.Sprintf("SELECT %s FROM %s ORDER BY created_ts DESC", strings.Join(cols, ","), frm.TableName)
-		rows, err = db.QueryContext(ctx, query)
-	} else {
-		query := fmt.Sprintf("SELECT %s FROM %s WHERE created_user = ", strings.Join(cols, ","), frm.TableName)
-		if dbType == DbSqlServer {
-			query += "@p1 ORDER BY created_ts DESC"
-		} else {
-			query += "$1 ORDER BY created_ts DESC"
-		}
-		rows, err = db.QueryContext(ctx, query, user)
+	for _, fld := range fields {
+		cols = append(cols, selectColumn(fld.Name, fld.FieldType))
+		val := emptyFormVal(fld.FieldType)
+		vals = append(vals, &val)
 	}
 
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s%s%s",
+		strings.Join(cols, ","),
+		quoteTable(frm.TableName),
+		where,
+		orderBy)
+	query = dialect.Paginate(query, limit, offset)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return make([]map[string]string, 0), nil
@@ -257,6 +523,7 @@ func loadFormList(ctx context.Context, user string, frm *Form) ([]map[string]str
 	for rows.Next() {
 		err = rows.Scan(vals...)
 		if err != nil {
+			_ = rows.Close()
 			return nil, errors.Wrap(err, "unable to read table values")
 		}
 		outRow := make(map[string]string)
@@ -269,152 +536,132 @@ func loadFormList(ctx context.Context, user string, frm *Form) ([]map[string]str
 		outRow["created_ts"] = formValFromInterface(FormTimeStamp, vals[i])
 		i++
 		// now the rest
-		for _, fld := range frm.Fields {
-			if fld.IncludeInSummary {
-				outRow[fld.Name] = formValFromInterface(fld.FieldType, vals[i])
-				i++
-			}
+		for _, fld := range fields {
+			outRow[fld.Name] = formValFromInterface(fld.FieldType, vals[i])
+			i++
 		}
 		out = append(out, outRow)
 	}
 	if closeErr := rows.Close(); closeErr != nil {
-		return nil, errors.Wrap(err, "unable to close rows for table values")
+		return nil, errors.Wrap(closeErr, "unable to close rows for table values")
+	}
+
+	if err := addJunctionValues(ctx, db, frm, out, summaryOnly); err != nil {
+		return nil, err
 	}
 
 	return out, nil
 }
 
-func loadFormEntry(ctx context.Context, username string, id int, frm *Form) (map[string]string, error) {
-	cols := make([]string, 0, len(frm.Fields))
+func loadFormEntry(ctx context.Context, username string, id int, frm *Form) (map[string]string, groupRows, error) {
+	return loadEntry(ctx, db, username, id, frm)
+}
+
+// loadEntry is loadFormEntry, reading with q so it can be part of a transaction
+func loadEntry(ctx context.Context, q queryer, username string, id int, frm *Form) (map[string]string, groupRows, error) {
+	cols := make([]string, 0, len(frm.Fields)+1)
 	vals := make([]interface{}, 0, len(cols))
+	// updated_ts is read as text, the same as generateUpdateStatement compares it
+	cols = append(cols, dialect.CastText("updated_ts"))
+	var valUpdated interface{} = ""
+	vals = append(vals, &valUpdated)
 	for _, fld := range frm.Fields {
-		if fld.FieldType == FormMoney && dbType == DbPostgres {
-			cols = append(cols, fld.Name+"::numeric")
-		} else {
-			cols = append(cols, fld.Name)
+		if !fld.isColumn() {
+			continue
 		}
+		cols = append(cols, selectColumn(fld.Name, fld.FieldType))
 		val := emptyFormVal(fld.FieldType)
 		vals = append(vals, &val)
 	}
 
 	isAdmin, _ := frm.Admins[username]
 
-	query := fmt.Sprintf("SELECT %s FROM %s WHERE ", strings.Join(cols, ","), frm.TableName)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE ", strings.Join(cols, ","), quoteTable(frm.TableName))
 	var err error
 	if isAdmin {
-		if dbType == DbSqlServer {
-			query += "id = @p1"
-		} else {
-			query += "id = $1"
-		}
-		err = db.QueryRowContext(ctx, query, id).Scan(vals...)
+		query += "id = " + dialect.Placeholder(1) + frm.notDeleted("AND")
+		err = q.QueryRowContext(ctx, query, id).Scan(vals...)
 	} else {
-		if dbType == DbSqlServer {
-			query += "id = @p1 AND created_user = @p2"
-		} else {
-			query += "id = $1 AND created_user = $2"
-		}
-		err = db.QueryRowContext(ctx, query, id, username).Scan(vals...)
+		query += "id = " + dialect.Placeholder(1) + " AND created_user = " + dialect.Placeholder(2) +
+			frm.notDeleted("AND")
+		err = q.QueryRowContext(ctx, query, id, username).Scan(vals...)
 	}
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.Wrap(err, "Unable to find record")
+			return nil, nil, errors.Wrap(err, "Unable to find record")
 		}
-		return nil, errors.Wrap(err, "loadFormEntry query error")
+		return nil, nil, errors.Wrap(err, "loadFormEntry query error")
 	}
 
 	outRow := make(map[string]string)
-	i := 0
+	outRow["id"] = strconv.Itoa(id)
+	outRow["updated_ts"] = formValFromInterface(FormVarChar, vals[0])
+	i := 1
 	for _, fld := range frm.Fields {
+		if !fld.isColumn() {
+			continue
+		}
 		outRow[fld.Name] = formValFromInterface(fld.FieldType, vals[i])
 		i++
 	}
 
-	return outRow, nil
+	if err := addJunctionValues(ctx, q, frm, []map[string]string{outRow}, false); err != nil {
+		return nil, nil, err
+	}
+
+	if err := addAttachmentValues(ctx, q, frm, []map[string]string{outRow}); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := loadGroupRows(ctx, q, frm, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return outRow, rows, nil
 }
 
 func generateInsertStatement(tableName string, fields []*FormField) string {
-	fieldNames := make([]string, 0, len(fields))
+	fieldNames := make([]string, 0, len(fields)+3)
+	fieldNames = append(fieldNames, "created_ts", "updated_ts", "created_user")
 	for _, field := range fields {
-		fieldNames = append(fieldNames, field.Name)
-	}
-	query := ""
-	if dbType == DbPostgres {
-		placeholders := make([]string, 0, len(fields))
-		for i, _ := range fields {
-			placeholders = append(placeholders, fmt.Sprintf("$%d", i+2))
-		}
-		query = fmt.Sprintf(
-			`INSERT INTO %s
-				(created_ts, updated_ts, created_user, %s)
-				VALUES (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, $1, %s) RETURNING id`,
-			tableName,
-			strings.Join(fieldNames, ","),
-			strings.Join(placeholders, ","))
-	} else {
-		placeholders := make([]string, 0, len(fields))
-		for i, _ := range fields {
-			placeholders = append(placeholders, fmt.Sprintf("@p%d", i+2))
+		if field.isColumn() {
+			fieldNames = append(fieldNames, field.Name)
 		}
-		query = fmt.Sprintf(
-			`INSERT INTO %s
-				(created_ts, updated_ts, created_user, %s) OUTPUT INSERTED.id
-				VALUES (CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, @p1, %s)`,
-			tableName,
-			strings.Join(fieldNames, ","),
-			strings.Join(placeholders, ","))
-	}
-	return query
+	}
+	vals := make([]string, 0, len(fieldNames))
+	vals = append(vals, "CURRENT_TIMESTAMP", dialect.Now())
+	vals = append(vals, placeholders(1, len(fieldNames)-2)...)
+	return dialect.InsertStatement(quoteTable(tableName), quoteIdents(fieldNames), vals)
 }
 
+// generateUpdateStatement builds an update taking each of the (non-ldap) fields, then the id, username
+// and updated_ts loaded with the record as arguments, in that order so that positional placeholders can
+// be used. No rows will be affected if the user is not permitted to update the record, or if it has been
+// updated since it was loaded.
 func generateUpdateStatement(tableName string, isAdmin bool, fields []*FormField) string {
-	fieldNames := make([]string, 0, len(fields))
+	placeholders := ""
+	n := 0
 	for _, field := range fields {
 		// ldap fields cannot be updated.
-		if !field.IsLDAPPopulated {
-			fieldNames = append(fieldNames, field.Name)
+		if !field.IsLDAPPopulated && field.isColumn() {
+			n++
+			placeholders = fmt.Sprintf(
+				"%s, %s = %s", placeholders, dialect.QuoteIdent(field.Name), dialect.Placeholder(n))
 		}
 	}
-	query := ""
-	if dbType == DbPostgres {
-		placeholders := ""
-		for i, field := range fields {
-			if !field.IsLDAPPopulated {
-				placeholders = fmt.Sprintf("%s, %s = $%d", placeholders, field.Name, i+3)
-			}
-		}
-		if isAdmin {
-			query = fmt.Sprintf(
-				`UPDATE %s SET updated_ts = CURRENT_TIMESTAMP %s WHERE id = $1 and $2 <> '' RETURNING id`,
-				tableName,
-				placeholders)
-		} else {
-			query = fmt.Sprintf(
-				`UPDATE %s SET updated_ts = CURRENT_TIMESTAMP %s WHERE id = $1 and created_user = $2 RETURNING id`,
-				tableName,
-				placeholders)
-		}
-	} else {
-		placeholders := ""
-		for i, field := range fields {
-			if !field.IsLDAPPopulated {
-				placeholders = fmt.Sprintf("%s, %s = @p%d", placeholders, field.Name, i+3)
-			}
-		}
-		if isAdmin {
-			query = fmt.Sprintf(
-				`UPDATE %s SET updated_ts = CURRENT_TIMESTAMP %s OUTPUT INSERTED.id WHERE id = @p1 AND @p2 <> ''`,
-				tableName,
-				placeholders)
-		} else {
-			query = fmt.Sprintf(
-				`UPDATE %s SET updated_ts = CURRENT_TIMESTAMP %s OUTPUT INSERTED.id WHERE id = @p1 AND created_user = @p2`,
-				tableName,
-				placeholders)
-		}
+	where := "id = " + dialect.Placeholder(n+1) + " AND " + dialect.Placeholder(n+2) + " <> ''"
+	if !isAdmin {
+		where = "id = " + dialect.Placeholder(n+1) + " AND created_user = " + dialect.Placeholder(n+2)
 	}
-	return query
+	where += " AND " + dialect.CastText("updated_ts") + " = " + dialect.Placeholder(n+3)
+	return fmt.Sprintf(
+		`UPDATE %s SET updated_ts = %s %s WHERE %s`,
+		quoteTable(tableName),
+		dialect.Now(),
+		placeholders,
+		where)
 }
 
 func minOffsetToTZOffset(minsStr string) string {
@@ -424,13 +671,25 @@ func minOffsetToTZOffset(minsStr string) string {
 		return "+00:00"
 	}
 	tzOffset = tzOffset * -1
+	sign := "+"
+	if tzOffset < 0 {
+		sign = "-"
+		tzOffset = tzOffset * -1
+	}
 	hrs := tzOffset / 60
 	mins := tzOffset % 60
-	out := fmt.Sprintf("%02d:%02d", hrs, mins)
-	if tzOffset >= 0 {
-		return "+" + out
+	return fmt.Sprintf("%s%02d:%02d", sign, hrs, mins)
+}
+
+// parseTimestamp reads a datetime-local input value in the browser's timezone offset (e.g. +10:00).
+// Passing a time.Time through to the driver rather than a string means each database can store it natively.
+func parseTimestamp(value string, tzOffset string) (time.Time, error) {
+	t, err := time.Parse(DateTimeLocal+"-07:00", value+tzOffset)
+	if err != nil {
+		// some browsers include the seconds
+		return time.Parse(DateTimeLocal+":05-07:00", value+tzOffset)
 	}
-	return "-" + out
+	return t, nil
 }
 
 func connectToDb(conf tomlConfig) {
@@ -438,7 +697,11 @@ func connectToDb(conf tomlConfig) {
 
 	// connect to the database
 	dbType = conf.Database.DbType
-	db, err = sql.Open(string(dbType), conf.Database.ConnectionString)
+	dialect, err = dialectFor(dbType)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err = dialect.Open(conf.Database.ConnectionString)
 	if err != nil {
 		log.Fatal(err)
 	}
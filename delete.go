@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+var deleteTemplate *template.Template
+
+// notDeleted is the condition hiding soft deleted records, joined to the rest of the
+// where clause with conj
+func (f *Form) notDeleted(conj string) string {
+	if !f.SoftDelete {
+		return ""
+	}
+	return " " + conj + " deleted_ts IS NULL"
+}
+
+// deleteFormEntry removes the record, or marks it deleted if the table has a deleted_ts column.
+// As with updates, only admins and the user who created the record can delete it.
+func deleteFormEntry(ctx context.Context, username string, frm *Form, id int) error {
+	where := "id = " + dialect.Placeholder(1) + frm.notDeleted("AND")
+	args := []interface{}{id}
+	if isAdmin, _ := frm.Admins[username]; !isAdmin {
+		where += " AND created_user = " + dialect.Placeholder(2)
+		args = append(args, username)
+	}
+
+	if frm.SoftDelete {
+		query := fmt.Sprintf("UPDATE %s SET deleted_ts = CURRENT_TIMESTAMP WHERE %s", quoteTable(frm.TableName), where)
+		return execDelete(ctx, db, query, args...)
+	}
+
+	// the rows in other tables go first, they reference the record
+	allowed, err := canAccessRecord(ctx, username, frm, id)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return errors.Wrap(sql.ErrNoRows, "Unable to find record")
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "unable to start transaction")
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, field := range frm.Fields {
+		table, parentColumn := "", ""
+		switch {
+		case field.junction != nil:
+			table, parentColumn = field.junction.table, field.junction.parentColumn
+		case field.Group != nil:
+			table, parentColumn = field.Group.table, field.Group.parentColumn
+		case field.attachments != nil:
+			table, parentColumn = field.attachments.table, field.attachments.parentColumn
+		default:
+			continue
+		}
+		query := fmt.Sprintf(
+			"DELETE FROM %s WHERE %s = %s",
+			quoteTable(table),
+			dialect.QuoteIdent(parentColumn),
+			dialect.Placeholder(1))
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to delete from %s", table))
+		}
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s", quoteTable(frm.TableName), where)
+	if err := execDelete(ctx, tx, query, args...); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "unable to commit")
+	}
+	return nil
+}
+
+// execDelete runs the delete, or soft delete, checking a record was affected
+func execDelete(ctx context.Context, q queryer, query string, args ...interface{}) error {
+	res, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return errors.Wrap(err, "unable to delete record")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "unable to determine deleted rows")
+	}
+	if affected == 0 {
+		return errors.Wrap(sql.ErrNoRows, "Unable to find record")
+	}
+	return nil
+}
+
+// deleteStatus is the http status for an error deleting a record, not found when the user can't
+// see the record
+func deleteStatus(err error) int {
+	if errors.Cause(err) == sql.ErrNoRows {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// ServeDelete asks the user to confirm they want to delete the record, then deletes it
+func ServeDelete(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	ctx := req.Context()
+
+	frm, err := forms.get(ctx, vars["table_name"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	username, ok := formUser(w, req, frm)
+	if !ok {
+		return
+	}
+	id, _ := strconv.Atoi(vars["id"])
+
+	if req.Method == http.MethodPost {
+		if err := deleteFormEntry(ctx, username, frm, id); err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), deleteStatus(err))
+			return
+		}
+		http.Redirect(w, req, "/"+frm.Path+"/list", http.StatusSeeOther)
+		return
+	}
+
+	vals, _, err := loadFormEntry(ctx, username, id, frm)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err = deleteTemplate.Execute(buf, map[string]interface{}{
+		"frm": frm, "vals": vals, "username": username,
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = buf.WriteTo(w)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// Dialect hides the differences between the supported database backends so the
+// query building in db.go doesn't need to know which server it's talking to.
+type Dialect interface {
+	// Open connects to the database using the driver for this dialect
+	Open(connectionString string) (*sql.DB, error)
+	// Placeholder returns the bind parameter for the n-th (1 based) argument
+	Placeholder(n int) string
+	// QuoteIdent quotes a table or column name
+	QuoteIdent(name string) string
+	// TableColumns introspects the columns of the table, skipping the first skip columns.
+	// An empty schema means the default schema for the connection.
+	TableColumns(ctx context.Context, schema string, tableName string, skip int) ([]*dbCol, error)
+	// ForeignKeys introspects the single column foreign keys of the table
+	ForeignKeys(ctx context.Context, schema string, tableName string) ([]*foreignKey, error)
+	// FieldType maps a database data type onto the form field type used to render it
+	FieldType(dataType string) FormFieldType
+	// SelectColumn returns the select expression used to read a column of the given type
+	SelectColumn(name string, fieldType FormFieldType) string
+	// CastText converts the expression to a string, e.g. so it can be searched with LIKE
+	CastText(expr string) string
+	// LikeEscape is the ESCAPE clause for the patterns made by containsPattern
+	LikeEscape() string
+	// FullTextSearch reports whether the table can be searched with the database's full-text search
+	FullTextSearch(ctx context.Context, schema string, tableName string) (bool, error)
+	// TextSearch is a condition matching rows where any of the (quoted) columns contain the search
+	// term, using full-text search if the table has it, along with its args starting from the
+	// start-th placeholder
+	TextSearch(cols []string, term string, start int, fullText bool) (string, []interface{})
+	// Paginate limits the results of a query, which must have an ORDER BY clause
+	Paginate(query string, limit int, offset int) string
+	// InsertStatement builds an insert that can be run with InsertReturningID
+	InsertStatement(tableName string, cols []string, vals []string) string
+	// InsertReturningID runs an insert built by InsertStatement and returns the new row id
+	InsertReturningID(ctx context.Context, q queryer, query string, args ...interface{}) (int, error)
+	// Now is the current timestamp with fractional seconds, so updated_ts changes with every save
+	Now() string
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+var dialect Dialect
+
+func dialectFor(t DBType) (Dialect, error) {
+	switch t {
+	case DbPostgres:
+		return postgresDialect{}, nil
+	case DbSqlServer:
+		return sqlServerDialect{}, nil
+	case DbMySQL:
+		return mysqlDialect{}, nil
+	case DbSQLite:
+		return sqliteDialect{}, nil
+	}
+	return nil, errors.Errorf("unsupported dbType %s", t)
+}
+
+// selectColumn is the expression to read the field's column. Files aren't read with the rest of
+// the record, just whether there is one.
+func selectColumn(name string, fieldType FormFieldType) string {
+	if fieldType == FormFile {
+		return fmt.Sprintf("CASE WHEN %s IS NULL THEN 0 ELSE 1 END", dialect.QuoteIdent(name))
+	}
+	return dialect.SelectColumn(name, fieldType)
+}
+
+// placeholders returns the bind parameters for count arguments, starting from the start-th argument
+func placeholders(start int, count int) []string {
+	out := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		out = append(out, dialect.Placeholder(start+i))
+	}
+	return out
+}
+
+// splitTableName separates a schema qualified table name (e.g. hr.leave_requests),
+// the schema is empty if the name isn't qualified
+func splitTableName(tableName string) (string, string) {
+	if i := strings.LastIndex(tableName, "."); i >= 0 {
+		return tableName[:i], tableName[i+1:]
+	}
+	return "", tableName
+}
+
+// quoteTable quotes a possibly schema qualified table name with the active dialect
+func quoteTable(tableName string) string {
+	schema, table := splitTableName(tableName)
+	if schema == "" {
+		return dialect.QuoteIdent(table)
+	}
+	return dialect.QuoteIdent(schema) + "." + dialect.QuoteIdent(table)
+}
+
+// quoteIdents quotes each of the names with the active dialect
+func quoteIdents(names []string) []string {
+	out := make([]string, 0, len(names))
+	for _, n := range names {
+		out = append(out, dialect.QuoteIdent(n))
+	}
+	return out
+}
+
+// foreignKey is a column that references another table
+type foreignKey struct {
+	column    string
+	refTable  string
+	refColumn string
+}
+
+// scanForeignKeys reads the column, referenced schema, table and column returned by the introspection queries
+func scanForeignKeys(rows *sql.Rows) ([]*foreignKey, error) {
+	fks := make([]*foreignKey, 0)
+	for rows.Next() {
+		fk := foreignKey{}
+		refSchema := ""
+		err := rows.Scan(&fk.column, &refSchema, &fk.refTable, &fk.refColumn)
+		if err != nil {
+			_ = rows.Close()
+			return nil, errors.Wrap(err, "unable to read foreign key metadata")
+		}
+		if refSchema != "" {
+			fk.refTable = refSchema + "." + fk.refTable
+		}
+		fks = append(fks, &fk)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, errors.Wrap(err, "unable to close foreign key rows")
+	}
+	return fks, nil
+}
+
+// containsPattern is a LIKE pattern matching values containing the term, with the wildcards in the
+// term escaped by a backslash
+func containsPattern(term string) string {
+	return "%" + likePatternEscaper.Replace(term) + "%"
+}
+
+// likePatternEscaper escapes the LIKE wildcards, including SQL Server's character ranges
+var likePatternEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`, `[`, `\[`)
+
+// likeAny is a condition matching rows where any of the columns contain the term, compared with op
+// (LIKE or ILIKE), and its args starting from the start-th placeholder
+func likeAny(cols []string, op string, term string, start int) (string, []interface{}) {
+	conds := make([]string, 0, len(cols))
+	args := make([]interface{}, 0, len(cols))
+	for i, col := range cols {
+		conds = append(conds, col+" "+op+" "+dialect.Placeholder(start+i)+dialect.LikeEscape())
+		args = append(args, containsPattern(term))
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
+
+// limitOffset is the LIMIT clause shared by most of the databases
+func limitOffset(query string, limit int, offset int) string {
+	return fmt.Sprintf("%s LIMIT %d OFFSET %d", query, limit, offset)
+}
+
+// scanColumns reads the name, type, not null, max length columns returned by the introspection queries.
+// A max length of zero or less means there is no limit.
+func scanColumns(rows *sql.Rows) ([]*dbCol, error) {
+	cols := make([]*dbCol, 0)
+	for rows.Next() {
+		col := dbCol{}
+		err := rows.Scan(&col.name, &col.colType, &col.notNull, &col.maxLength)
+		if err != nil {
+			_ = rows.Close()
+			return nil, errors.Wrap(err, "unable to read table column metadata")
+		}
+		cols = append(cols, &col)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, errors.Wrap(err, "unable to close column metadata rows")
+	}
+	return cols, nil
+}
+
+// execReturningLastInsertID runs the insert and returns the id reported by the driver, for
+// databases that can't return values from an insert statement
+func execReturningLastInsertID(ctx context.Context, q queryer, query string, args ...interface{}) (int, error) {
+	res, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to read inserted id")
+	}
+	return int(id), nil
+}
+
+// typeLength reads the length from a type declaration like VARCHAR(20), returning 0 if there isn't one
+func typeLength(colType string) int {
+	start := strings.Index(colType, "(")
+	end := strings.Index(colType, ")")
+	if start < 0 || end < start {
+		return 0
+	}
+	l, err := strconv.Atoi(strings.TrimSpace(colType[start+1 : end]))
+	if err != nil {
+		return 0
+	}
+	return l
+}
+
+// quoteWith doubles any embedded close characters and wraps the name in open/close
+func quoteWith(name string, open string, close string) string {
+	return open + strings.ReplaceAll(name, close, close+close) + close
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Open(connectionString string) (*sql.DB, error) {
+	cfg, err := mysql.ParseDSN(connectionString)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse mysql connection string")
+	}
+	// we need time.Time values for the date fields, and the matched (not changed) row
+	// count so that an update which doesn't alter any values isn't seen as a failure
+	cfg.ParseTime = true
+	cfg.ClientFoundRows = true
+	return sql.Open("mysql", cfg.FormatDSN())
+}
+
+func (mysqlDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (mysqlDialect) QuoteIdent(name string) string {
+	return quoteWith(name, "`", "`")
+}
+
+func (mysqlDialect) TableColumns(ctx context.Context, schema string, tableName string, skip int) ([]*dbCol, error) {
+	// COLUMN_TYPE rather than DATA_TYPE so we can tell tinyint(1) booleans apart
+	query := `
+		SELECT COLUMN_NAME,
+			   COLUMN_TYPE,
+			   IS_NULLABLE = 'NO',
+			   IF(DATA_TYPE IN ('varchar', 'char'), COALESCE(CHARACTER_MAXIMUM_LENGTH, 0), 0)
+		FROM information_schema.columns
+		WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE())
+		  AND table_name = ?
+		  AND ORDINAL_POSITION > ?
+		ORDER BY ordinal_position
+		`
+	rows, err := db.QueryContext(ctx, query, schema, tableName, skip)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query table metadata")
+	}
+	return scanColumns(rows)
+}
+
+func (mysqlDialect) ForeignKeys(ctx context.Context, schema string, tableName string) ([]*foreignKey, error) {
+	query := `
+		SELECT k.COLUMN_NAME,
+			   k.REFERENCED_TABLE_SCHEMA,
+			   k.REFERENCED_TABLE_NAME,
+			   k.REFERENCED_COLUMN_NAME
+		FROM information_schema.KEY_COLUMN_USAGE k
+		WHERE k.TABLE_SCHEMA = COALESCE(NULLIF(?, ''), DATABASE())
+		  AND k.TABLE_NAME = ?
+		  AND k.REFERENCED_TABLE_NAME IS NOT NULL
+		  AND (SELECT COUNT(*)
+			   FROM information_schema.KEY_COLUMN_USAGE x
+			   WHERE x.CONSTRAINT_SCHEMA = k.CONSTRAINT_SCHEMA
+				 AND x.CONSTRAINT_NAME = k.CONSTRAINT_NAME
+				 AND x.TABLE_NAME = k.TABLE_NAME) = 1
+		`
+	rows, err := db.QueryContext(ctx, query, schema, tableName)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query foreign keys")
+	}
+	return scanForeignKeys(rows)
+}
+
+func (mysqlDialect) FieldType(dt string) FormFieldType {
+	dt = strings.ToLower(dt)
+	if strings.HasPrefix(dt, "tinyint(1)") || dt == "boolean" || dt == "bool" {
+		return FormBoolean
+	}
+	// drop the display width / precision and any unsigned or zerofill modifiers
+	if i := strings.IndexAny(dt, "( "); i >= 0 {
+		dt = dt[:i]
+	}
+	switch dt {
+	case "varchar", "char":
+		return FormVarChar
+	case "text", "tinytext", "mediumtext", "longtext":
+		return FormText
+	case "int", "integer", "tinyint", "smallint", "mediumint", "bigint":
+		return FormInteger
+	case "decimal", "numeric":
+		return FormDecimal
+	case "float", "double", "real":
+		return FormFloat
+	case "datetime", "timestamp":
+		return FormTimeStamp
+	case "date":
+		return FormDate
+	case "blob", "tinyblob", "mediumblob", "longblob", "varbinary", "binary":
+		return FormFile
+	}
+	return FormVarChar
+}
+
+func (d mysqlDialect) SelectColumn(name string, fieldType FormFieldType) string {
+	return d.QuoteIdent(name)
+}
+
+func (mysqlDialect) CastText(expr string) string {
+	return "CAST(" + expr + " AS CHAR)"
+}
+
+func (mysqlDialect) LikeEscape() string {
+	// backslashes are escapes in string literals too
+	return ` ESCAPE '\\'`
+}
+
+func (mysqlDialect) FullTextSearch(ctx context.Context, schema string, tableName string) (bool, error) {
+	return false, nil
+}
+
+func (mysqlDialect) TextSearch(cols []string, term string, start int, fullText bool) (string, []interface{}) {
+	// LIKE is case insensitive for the default collation
+	return likeAny(cols, "LIKE", term, start)
+}
+
+func (mysqlDialect) Paginate(query string, limit int, offset int) string {
+	return limitOffset(query, limit, offset)
+}
+
+func (mysqlDialect) InsertStatement(tableName string, cols []string, vals []string) string {
+	return fmt.Sprintf(
+		`INSERT INTO %s
+			(%s)
+			VALUES (%s)`,
+		tableName,
+		strings.Join(cols, ","),
+		strings.Join(vals, ","))
+}
+
+func (mysqlDialect) InsertReturningID(ctx context.Context, q queryer, query string, args ...interface{}) (int, error) {
+	// the driver reads this from the OK packet, equivalent to LAST_INSERT_ID()
+	return execReturningLastInsertID(ctx, q, query, args...)
+}
+
+func (mysqlDialect) Now() string {
+	return "CURRENT_TIMESTAMP(6)"
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"log"
+	"strings"
+	"time"
+)
+
+type postgresDialect struct{}
+
+func (postgresDialect) Open(connectionString string) (*sql.DB, error) {
+	return sql.Open("postgres", connectionString)
+}
+
+func (postgresDialect) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (postgresDialect) QuoteIdent(name string) string {
+	return quoteWith(name, `"`, `"`)
+}
+
+func (postgresDialect) TableColumns(ctx context.Context, schema string, tableName string, skip int) ([]*dbCol, error) {
+	query := `
+		SELECT f.attname,
+			   pg_catalog.format_type(f.atttypid, f.atttypmod),
+       		   f.attnotnull,
+			   CASE
+			       WHEN f.atttypid IN ('varchar'::regtype, 'bpchar'::regtype) AND f.atttypmod > 4
+			       THEN f.atttypmod - 4
+			       ELSE 0
+			   END
+		FROM
+			pg_attribute f
+			JOIN pg_class c ON c.oid = f.attrelid
+			LEFT JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind = 'r'::char
+		  AND n.nspname = COALESCE(NULLIF($1, ''), current_schema())
+		  AND c.relname = $2
+		  AND f.attnum > $3
+		  AND NOT f.attisdropped
+		ORDER BY f.attnum
+		`
+	rows, err := db.QueryContext(ctx, query, schema, tableName, skip)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query table metadata")
+	}
+	return scanColumns(rows)
+}
+
+func (postgresDialect) ForeignKeys(ctx context.Context, schema string, tableName string) ([]*foreignKey, error) {
+	query := `
+		SELECT a.attname,
+			   rn.nspname,
+			   rc.relname,
+			   ra.attname
+		FROM pg_constraint con
+			JOIN pg_class c ON c.oid = con.conrelid
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = con.conkey[1]
+			JOIN pg_class rc ON rc.oid = con.confrelid
+			JOIN pg_namespace rn ON rn.oid = rc.relnamespace
+			JOIN pg_attribute ra ON ra.attrelid = con.confrelid AND ra.attnum = con.confkey[1]
+		WHERE con.contype = 'f'
+		  AND array_length(con.conkey, 1) = 1
+		  AND n.nspname = COALESCE(NULLIF($1, ''), current_schema())
+		  AND c.relname = $2
+		`
+	rows, err := db.QueryContext(ctx, query, schema, tableName)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query foreign keys")
+	}
+	return scanForeignKeys(rows)
+}
+
+func (postgresDialect) FieldType(dt string) FormFieldType {
+	// format_type includes any modifiers, e.g. character varying(20)
+	if i := strings.Index(dt, "("); i >= 0 {
+		if j := strings.Index(dt[i:], ")"); j >= 0 {
+			dt = strings.TrimSpace(dt[:i] + dt[i+j+1:])
+		}
+	}
+	switch dt {
+	case "character varying":
+		return FormVarChar
+	case "text":
+		return FormText
+	case "integer":
+		return FormInteger
+	case "numeric":
+		return FormDecimal
+	case "money":
+		return FormMoney
+	case "double precision":
+		return FormFloat
+	case "boolean":
+		return FormBoolean
+	case "timestamp with time zone":
+		return FormTimeStamp
+	case "date":
+		return FormDate
+	case "text[]", "character varying[]":
+		return FormMultiSelect
+	case "bytea":
+		return FormFile
+	}
+	return FormVarChar
+}
+
+func (d postgresDialect) SelectColumn(name string, fieldType FormFieldType) string {
+	// money is returned formatted with the locale's currency symbol unless cast
+	if fieldType == FormMoney {
+		return d.QuoteIdent(name) + "::numeric"
+	}
+	return d.QuoteIdent(name)
+}
+
+func (postgresDialect) CastText(expr string) string {
+	return "CAST(" + expr + " AS TEXT)"
+}
+
+func (postgresDialect) LikeEscape() string {
+	return ` ESCAPE '\'`
+}
+
+func (postgresDialect) FullTextSearch(ctx context.Context, schema string, tableName string) (bool, error) {
+	// to_tsvector works on any table, an index only makes it faster
+	return true, nil
+}
+
+func (d postgresDialect) TextSearch(cols []string, term string, start int, fullText bool) (string, []interface{}) {
+	if !fullText {
+		return likeAny(cols, "ILIKE", term, start)
+	}
+	like, args := likeAny(cols, "ILIKE", term, start+1)
+	cond := fmt.Sprintf(
+		"(to_tsvector(concat_ws(' ', %s)) @@ plainto_tsquery(%s) OR %s)",
+		strings.Join(cols, ", "),
+		d.Placeholder(start),
+		like)
+	return cond, append([]interface{}{term}, args...)
+}
+
+func (postgresDialect) Paginate(query string, limit int, offset int) string {
+	return limitOffset(query, limit, offset)
+}
+
+func (postgresDialect) InsertStatement(tableName string, cols []string, vals []string) string {
+	return fmt.Sprintf(
+		`INSERT INTO %s
+			(%s)
+			VALUES (%s) RETURNING id`,
+		tableName,
+		strings.Join(cols, ","),
+		strings.Join(vals, ","))
+}
+
+func (postgresDialect) InsertReturningID(ctx context.Context, q queryer, query string, args ...interface{}) (int, error) {
+	id := 0
+	err := q.QueryRowContext(ctx, query, args...).Scan(&id)
+	return id, err
+}
+
+func (postgresDialect) Now() string {
+	// already has microseconds
+	return "CURRENT_TIMESTAMP"
+}
+
+// ListenForFormChanges listens on the form_changed channel, see setup.pgsql.sql for the triggers
+// that notify it
+func (postgresDialect) ListenForFormChanges(connectionString string, invalidate func(name string)) error {
+	listener := pq.NewListener(connectionString, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("form change listener: %s", err)
+		}
+	})
+	if err := listener.Listen("form_changed"); err != nil {
+		return errors.Wrap(err, "unable to listen for form changes")
+	}
+	go func() {
+		for n := range listener.Notify {
+			// a nil notification means the connection was re-established, and we may have missed something
+			if n == nil {
+				invalidate("")
+				continue
+			}
+			invalidate(n.Extra)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Open(connectionString string) (*sql.DB, error) {
+	conn, err := sql.Open("sqlite3", connectionString)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only allows a single writer, sharing the one connection avoids "database is locked" errors
+	conn.SetMaxOpenConns(1)
+	return conn, nil
+}
+
+func (sqliteDialect) Placeholder(n int) string {
+	return fmt.Sprintf("?%d", n)
+}
+
+func (sqliteDialect) QuoteIdent(name string) string {
+	return quoteWith(name, `"`, `"`)
+}
+
+func (d sqliteDialect) TableColumns(ctx context.Context, schema string, tableName string, skip int) ([]*dbCol, error) {
+	// the table valued form of PRAGMA table_info, cid is zero based.
+	// the schema is the name of an attached database
+	if schema == "" {
+		schema = "main"
+	}
+	query := `
+		SELECT name,
+			   type,
+			   "notnull",
+			   0
+		FROM pragma_table_info(?1, ?2)
+		WHERE cid >= ?3
+		ORDER BY cid
+		`
+	rows, err := db.QueryContext(ctx, query, tableName, schema, skip)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query table metadata")
+	}
+	cols, err := scanColumns(rows)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite doesn't enforce the length, but we can for anything declared as e.g. VARCHAR(20)
+	for _, col := range cols {
+		if d.FieldType(col.colType) == FormVarChar {
+			col.maxLength = typeLength(col.colType)
+		}
+	}
+	return cols, nil
+}
+
+func (sqliteDialect) ForeignKeys(ctx context.Context, schema string, tableName string) ([]*foreignKey, error) {
+	if schema == "" {
+		schema = "main"
+	}
+	// a missing "to" column means the primary key of the referenced table. the referenced
+	// table is always in the same schema, so it's left unqualified
+	query := `
+		SELECT fk."from",
+			   '',
+			   fk."table",
+			   COALESCE(fk."to", (SELECT ti.name FROM pragma_table_info(fk."table", ?2) ti WHERE ti.pk = 1))
+		FROM pragma_foreign_key_list(?1, ?2) fk
+		WHERE (SELECT COUNT(*) FROM pragma_foreign_key_list(?1, ?2) x WHERE x.id = fk.id) = 1
+		`
+	rows, err := db.QueryContext(ctx, query, tableName, schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query foreign keys")
+	}
+	return scanForeignKeys(rows)
+}
+
+// FieldType maps the declared type of the column. sqlite will accept any type name, so after
+// checking for the common names we fall back to the column affinity rules:
+// https://www.sqlite.org/datatype3.html#determination_of_column_affinity
+func (sqliteDialect) FieldType(dt string) FormFieldType {
+	dt = strings.ToUpper(strings.TrimSpace(dt))
+	name := dt
+	if i := strings.Index(name, "("); i >= 0 {
+		name = strings.TrimSpace(name[:i])
+	}
+	switch name {
+	case "BOOLEAN", "BOOL":
+		return FormBoolean
+	case "DATE":
+		return FormDate
+	case "DATETIME", "TIMESTAMP", "TIMESTAMPTZ":
+		return FormTimeStamp
+	case "DECIMAL", "NUMERIC":
+		return FormDecimal
+	case "MONEY":
+		return FormMoney
+	case "TEXT", "CLOB":
+		return FormText
+	}
+
+	switch {
+	case strings.Contains(dt, "INT"):
+		return FormInteger
+	case strings.Contains(dt, "CHAR") || strings.Contains(dt, "CLOB") || strings.Contains(dt, "TEXT"):
+		return FormVarChar
+	case strings.Contains(dt, "REAL") || strings.Contains(dt, "FLOA") || strings.Contains(dt, "DOUB"):
+		return FormFloat
+	case strings.Contains(dt, "BLOB"):
+		return FormFile
+	case dt == "":
+		return FormVarChar
+	}
+	// numeric affinity
+	return FormDecimal
+}
+
+func (d sqliteDialect) SelectColumn(name string, fieldType FormFieldType) string {
+	return d.QuoteIdent(name)
+}
+
+func (sqliteDialect) CastText(expr string) string {
+	return "CAST(" + expr + " AS TEXT)"
+}
+
+func (sqliteDialect) LikeEscape() string {
+	return ` ESCAPE '\'`
+}
+
+func (sqliteDialect) FullTextSearch(ctx context.Context, schema string, tableName string) (bool, error) {
+	return false, nil
+}
+
+func (sqliteDialect) TextSearch(cols []string, term string, start int, fullText bool) (string, []interface{}) {
+	// LIKE is case insensitive for the default collation
+	return likeAny(cols, "LIKE", term, start)
+}
+
+func (sqliteDialect) Paginate(query string, limit int, offset int) string {
+	return limitOffset(query, limit, offset)
+}
+
+func (sqliteDialect) InsertStatement(tableName string, cols []string, vals []string) string {
+	return fmt.Sprintf(
+		`INSERT INTO %s
+			(%s)
+			VALUES (%s)`,
+		tableName,
+		strings.Join(cols, ","),
+		strings.Join(vals, ","))
+}
+
+func (sqliteDialect) InsertReturningID(ctx context.Context, q queryer, query string, args ...interface{}) (int, error) {
+	return execReturningLastInsertID(ctx, q, query, args...)
+}
+
+func (sqliteDialect) Now() string {
+	// CURRENT_TIMESTAMP is only to the second
+	return "strftime('%Y-%m-%d %H:%M:%f', 'now')"
+}
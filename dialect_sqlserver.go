@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+type sqlServerDialect struct{}
+
+func (sqlServerDialect) Open(connectionString string) (*sql.DB, error) {
+	return sql.Open("sqlserver", connectionString)
+}
+
+func (sqlServerDialect) Placeholder(n int) string {
+	return fmt.Sprintf("@p%d", n)
+}
+
+func (sqlServerDialect) QuoteIdent(name string) string {
+	return quoteWith(name, "[", "]")
+}
+
+func (sqlServerDialect) TableColumns(ctx context.Context, schema string, tableName string, skip int) ([]*dbCol, error) {
+	query := `
+		SELECT COLUMN_NAME,
+			   DATA_TYPE,
+			   IIF(IS_NULLABLE = 'NO', 1, 0),
+			   IIF(DATA_TYPE IN ('varchar', 'nvarchar', 'char', 'nchar'), COALESCE(CHARACTER_MAXIMUM_LENGTH, 0), 0)
+		FROM information_schema.columns
+		WHERE TABLE_SCHEMA = COALESCE(NULLIF(@p1, ''), SCHEMA_NAME())
+		  AND TABLE_NAME = @p2
+		  AND ORDINAL_POSITION > @p3
+		ORDER BY ordinal_position;
+		`
+	rows, err := db.QueryContext(ctx, query, schema, tableName, skip)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query table metadata")
+	}
+	return scanColumns(rows)
+}
+
+func (sqlServerDialect) ForeignKeys(ctx context.Context, schema string, tableName string) ([]*foreignKey, error) {
+	query := `
+		SELECT pc.name,
+			   SCHEMA_NAME(rt.schema_id),
+			   rt.name,
+			   rc.name
+		FROM sys.foreign_keys fk
+			JOIN sys.foreign_key_columns fkc ON fkc.constraint_object_id = fk.object_id
+			JOIN sys.tables pt ON pt.object_id = fk.parent_object_id
+			JOIN sys.columns pc ON pc.object_id = fkc.parent_object_id AND pc.column_id = fkc.parent_column_id
+			JOIN sys.tables rt ON rt.object_id = fk.referenced_object_id
+			JOIN sys.columns rc ON rc.object_id = fkc.referenced_object_id AND rc.column_id = fkc.referenced_column_id
+		WHERE SCHEMA_NAME(pt.schema_id) = COALESCE(NULLIF(@p1, ''), SCHEMA_NAME())
+		  AND pt.name = @p2
+		  AND (SELECT COUNT(*) FROM sys.foreign_key_columns x WHERE x.constraint_object_id = fk.object_id) = 1
+		`
+	rows, err := db.QueryContext(ctx, query, schema, tableName)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query foreign keys")
+	}
+	return scanForeignKeys(rows)
+}
+
+func (sqlServerDialect) FieldType(dt string) FormFieldType {
+	switch dt {
+	case "varchar":
+		return FormVarChar
+	case "text":
+		return FormText
+	case "int":
+		return FormInteger
+	case "decimal":
+		return FormDecimal
+	case "money":
+		return FormMoney
+	case "float":
+		return FormFloat
+	case "bit":
+		return FormBoolean
+	case "datetimeoffset":
+		return FormTimeStamp
+	case "date":
+		return FormDate
+	case "varbinary", "binary", "image":
+		return FormFile
+	}
+	return FormVarChar
+}
+
+func (d sqlServerDialect) SelectColumn(name string, fieldType FormFieldType) string {
+	return d.QuoteIdent(name)
+}
+
+func (sqlServerDialect) CastText(expr string) string {
+	return "CAST(" + expr + " AS NVARCHAR(MAX))"
+}
+
+func (sqlServerDialect) LikeEscape() string {
+	return ` ESCAPE '\'`
+}
+
+func (sqlServerDialect) FullTextSearch(ctx context.Context, schema string, tableName string) (bool, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM sys.fulltext_indexes fi
+			JOIN sys.tables t ON t.object_id = fi.object_id
+		WHERE SCHEMA_NAME(t.schema_id) = COALESCE(NULLIF(@p1, ''), SCHEMA_NAME())
+		  AND t.name = @p2
+		`
+	count := 0
+	if err := db.QueryRowContext(ctx, query, schema, tableName).Scan(&count); err != nil {
+		return false, errors.Wrap(err, "unable to query full-text indexes")
+	}
+	return count > 0, nil
+}
+
+func (d sqlServerDialect) TextSearch(cols []string, term string, start int, fullText bool) (string, []interface{}) {
+	if !fullText {
+		return likeAny(cols, "LIKE", term, start)
+	}
+	// each word of the term is searched for as a phrase, so the user can't enter CONTAINS syntax
+	words := make([]string, 0)
+	for _, w := range strings.Fields(term) {
+		words = append(words, `"`+strings.ReplaceAll(w, `"`, `""`)+`"`)
+	}
+	like, args := likeAny(cols, "LIKE", term, start+1)
+	cond := fmt.Sprintf("(CONTAINS(*, %s) OR %s)", d.Placeholder(start), like)
+	return cond, append([]interface{}{strings.Join(words, " AND ")}, args...)
+}
+
+func (sqlServerDialect) Paginate(query string, limit int, offset int) string {
+	return fmt.Sprintf("%s OFFSET %d ROWS FETCH NEXT %d ROWS ONLY", query, offset, limit)
+}
+
+func (sqlServerDialect) InsertStatement(tableName string, cols []string, vals []string) string {
+	return fmt.Sprintf(
+		`INSERT INTO %s
+			(%s) OUTPUT INSERTED.id
+			VALUES (%s)`,
+		tableName,
+		strings.Join(cols, ","),
+		strings.Join(vals, ","))
+}
+
+func (sqlServerDialect) InsertReturningID(ctx context.Context, q queryer, query string, args ...interface{}) (int, error) {
+	id := 0
+	err := q.QueryRowContext(ctx, query, args...).Scan(&id)
+	return id, err
+}
+
+func (sqlServerDialect) Now() string {
+	// CURRENT_TIMESTAMP is a datetime, only to a 300th of a second
+	return "SYSDATETIMEOFFSET()"
+}
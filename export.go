@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// exportBatchSize is the number of records read at a time when exporting, so a large table is
+// streamed rather than held in memory
+const exportBatchSize = 500
+
+// exportHeaders are the column headings of an export, the field labels after the record's id,
+// user and submitted time
+func exportHeaders(frm *Form) []string {
+	out := []string{"#", "User", "Submitted"}
+	for _, field := range frm.Fields {
+		out = append(out, field.Label)
+	}
+	return out
+}
+
+// exportValue is the field's value in an export. Groups and attachments are shown the same as in
+// the audit history, the rest as their form values.
+func exportValue(field *FormField, row map[string]string, rows groupRows) string {
+	if field.Group != nil || field.attachments != nil {
+		return auditValue(field, row, rows)
+	}
+	return row[field.Name]
+}
+
+// csvCell stops the value being run as a formula when the file is opened in a spreadsheet, by
+// prefixing anything that starts like one with a '. Numbers such as -5 are left as they are. Values
+// already starting with a ' get another, so importValue can tell which have been prefixed.
+func csvCell(value string) string {
+	if value == "" || !strings.ContainsAny(value[:1], "=+-@\t\r'") {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return "'" + value
+}
+
+// exportOrderBy sorts an export the same as the list, but with nulls first whichever way it's sorted,
+// as the databases put them at different ends
+func exportOrderBy(list *ListQuery) string {
+	col := dialect.QuoteIdent(list.Sort)
+	dir := " ASC"
+	if list.Desc {
+		dir = " DESC"
+	}
+	return " ORDER BY CASE WHEN " + col + " IS NULL THEN 0 ELSE 1 END" + dir + ", " + col + dir + ", id" + dir
+}
+
+// exportAfter is the condition for the records that come after the one with the id in the export's
+// order, and its args starting from the start-th placeholder. The record's sort value is read in a
+// subquery, so it doesn't have to be converted back from its form value.
+func exportAfter(list *ListQuery, table string, id string, start int) (string, []interface{}) {
+	col := dialect.QuoteIdent(list.Sort)
+	args := make([]interface{}, 0, 5)
+	// each use of the id has its own placeholder for the databases with positional ones
+	p := func() string {
+		args = append(args, id)
+		return dialect.Placeholder(start + len(args) - 1)
+	}
+	last := func() string {
+		return fmt.Sprintf("(SELECT %s FROM %s WHERE id = %s)", col, table, p())
+	}
+	if list.Desc {
+		cond := fmt.Sprintf(
+			"((%s IS NULL AND (%s IS NOT NULL OR id < %s)) OR %s < %s OR (%s = %s AND id < %s))",
+			col, last(), p(), col, last(), col, last(), p())
+		return cond, args
+	}
+	cond := fmt.Sprintf(
+		"((%s IS NULL AND (%s IS NOT NULL OR id > %s)) OR %s > %s OR (%s = %s AND id > %s))",
+		last(), col, p(), col, last(), col, last(), p())
+	return cond, args
+}
+
+// exportRecords calls each for every record the user can see that matches the list's filters and
+// search, with all of its fields, a batch at a time. flush is called after each batch. Each batch
+// carries on from the last record of the one before, rather than counting through the table again.
+func exportRecords(ctx context.Context, user string, frm *Form, list *ListQuery, each func(row map[string]string, rows groupRows) error, flush func() error) error {
+	where, args := listWhere(user, frm, list)
+	orderBy := exportOrderBy(list)
+	lastId := ""
+	for {
+		batchWhere, batchArgs := where, args
+		if lastId != "" {
+			after, afterArgs := exportAfter(list, quoteTable(frm.TableName), lastId, len(args)+1)
+			if where == "" {
+				batchWhere = " WHERE " + after
+			} else {
+				batchWhere = where + " AND " + after
+			}
+			batchArgs = append(append(make([]interface{}, 0, len(args)+len(afterArgs)), args...), afterArgs...)
+		}
+		batch, err := loadRecords(ctx, frm, false, batchWhere, batchArgs, orderBy, exportBatchSize, 0)
+		if err != nil {
+			return err
+		}
+		// the files and group rows of the batch are read together, rather than for each record
+		if err := addAttachmentValues(ctx, db, frm, batch); err != nil {
+			return err
+		}
+		ids := make([]string, 0, len(batch))
+		for _, row := range batch {
+			ids = append(ids, row["id"])
+		}
+		rows, err := loadRecordsGroupRows(ctx, db, frm, ids)
+		if err != nil {
+			return err
+		}
+		for _, row := range batch {
+			if err := each(row, rows[row["id"]]); err != nil {
+				return err
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+		lastId = batch[len(batch)-1]["id"]
+	}
+}
+
+// exportRequest reads the form, user and the list being exported, writing an error response if it can't
+func exportRequest(w http.ResponseWriter, req *http.Request) (*Form, string, *ListQuery, bool) {
+	frm, err := forms.get(req.Context(), mux.Vars(req)["table_name"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, "", nil, false
+	}
+	username, ok := formUser(w, req, frm)
+	if !ok {
+		return nil, "", nil, false
+	}
+	return frm, username, parseListQuery(frm, req), true
+}
+
+// ServeExportCSV downloads the records the user can see as a csv file, filtered and sorted the same
+// as the list they were viewing
+func ServeExportCSV(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	frm, username, list, ok := exportRequest(w, req)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+frm.Path+`.csv"`)
+	// each batch is held until it's been read, so nothing is sent before the first batch and an error
+	// can still be reported
+	buf := new(bytes.Buffer)
+	// the byte order mark tells Excel the file is utf-8
+	_, _ = buf.WriteString("\ufeff")
+	cw := csv.NewWriter(buf)
+	_ = cw.Write(exportHeaders(frm))
+	started := false
+
+	err := exportRecords(ctx, username, frm, list, func(row map[string]string, rows groupRows) error {
+		record := []string{row["id"], csvCell(row["created_user"]), row["created_ts"]}
+		for _, field := range frm.Fields {
+			record = append(record, csvCell(exportValue(field, row, rows)))
+		}
+		return cw.Write(record)
+	}, func() error {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		started = true
+		_, err := buf.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		log.Println(err)
+		if !started {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// ServeExportXLSX downloads the records the user can see as an Excel workbook, with cells of the
+// type of each field, filtered and sorted the same as the list they were viewing
+func ServeExportXLSX(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	frm, username, list, ok := exportRequest(w, req)
+	if !ok {
+		return
+	}
+
+	headers := exportHeaders(frm)
+	types := []FormFieldType{FormInteger, FormVarChar, FormTimeStamp}
+	widths := []float64{xlsxWidth(FormInteger, headers[0], 0), xlsxWidth(FormVarChar, headers[1], 0),
+		xlsxWidth(FormTimeStamp, headers[2], 0)}
+	for _, field := range frm.Fields {
+		fieldType := field.FieldType
+		if field.Options != nil && fieldType != FormMultiSelect {
+			// selects and radios hold the option's value, which may be a number
+			fieldType = FormVarChar
+		}
+		types = append(types, fieldType)
+		widths = append(widths, xlsxWidth(fieldType, field.Label, field.MaxLength))
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+frm.Path+`.xlsx"`)
+	// each batch is held until it's been read, so nothing is sent before the first batch and an error
+	// can still be reported
+	buf := new(bytes.Buffer)
+	started := false
+	sheet, err := newXLSXSheet(buf, frm.Name, widths)
+	if err == nil {
+		err = sheet.writeHeader(headers)
+	}
+	if err == nil {
+		err = exportRecords(ctx, username, frm, list, func(row map[string]string, rows groupRows) error {
+			values := []string{row["id"], row["created_user"], row["created_ts"]}
+			for _, field := range frm.Fields {
+				values = append(values, exportValue(field, row, rows))
+			}
+			return sheet.writeRow(types, values)
+		}, func() error {
+			if err := sheet.flush(); err != nil {
+				return err
+			}
+			started = true
+			_, err := buf.WriteTo(w)
+			return err
+		})
+	}
+	if err == nil {
+		if err = sheet.close(); err == nil {
+			_, err = buf.WriteTo(w)
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		if !started {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+)
+
+// TestExportAfter checks each record is followed by the rest of the export in order, with the sort
+// column's nulls and ties
+func TestExportAfter(t *testing.T) {
+	dialect = sqliteDialect{}
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Exec(`CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT);
+		INSERT INTO t (id, name) VALUES (1, 'b'), (2, NULL), (3, 'a'), (4, 'b'), (5, NULL), (6, 'c'), (7, 'a')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(t *testing.T, query string, args ...interface{}) []string {
+		rows, err := conn.Query(query, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		out := make([]string, 0)
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, id)
+		}
+		return out
+	}
+
+	for _, list := range []*ListQuery{{Sort: "name"}, {Sort: "name", Desc: true}, {Sort: "id", Desc: true}} {
+		t.Run(fmt.Sprintf("%s desc %v", list.Sort, list.Desc), func(t *testing.T) {
+			order := ids(t, "SELECT id FROM t"+exportOrderBy(list))
+			if len(order) != 7 {
+				t.Fatalf("got %v", order)
+			}
+			for i, id := range order {
+				cond, args := exportAfter(list, "t", id, 1)
+				got := ids(t, "SELECT id FROM t WHERE "+cond+exportOrderBy(list), args...)
+				if fmt.Sprint(got) != fmt.Sprint(order[i+1:]) {
+					t.Errorf("after %s got %v, want %v", id, got, order[i+1:])
+				}
+			}
+		})
+	}
+}
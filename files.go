@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var maxFileSize int64 = 10 << 20
+var maxRequestSize int64 = 50 << 20
+var allowedFileTypes []string
+
+func setupFiles(conf tomlConfig) {
+	if conf.Files.MaxSizeMB > 0 {
+		maxFileSize = int64(conf.Files.MaxSizeMB) << 20
+	}
+	if conf.Files.MaxRequestMB > 0 {
+		maxRequestSize = int64(conf.Files.MaxRequestMB) << 20
+	}
+	allowedFileTypes = conf.Files.AllowedTypes
+}
+
+// attachmentsTable is a side table holding any number of files for a file field, with the columns
+// id, the parent id column, file_name, content_type and content
+type attachmentsTable struct {
+	table        string
+	parentColumn string
+}
+
+// parseAttachmentsTable reads an attachments_table label, in the format table(parent_id_column)
+func parseAttachmentsTable(spec string) (*attachmentsTable, error) {
+	m := childTableSpec.FindStringSubmatch(spec)
+	if m == nil {
+		return nil, errors.Errorf("attachments table %q is not in the format table(parent_id_column)", spec)
+	}
+	return &attachmentsTable{table: m[1], parentColumn: m[2]}, nil
+}
+
+// Attachment is a file saved with a record, ID is empty for files stored in the field's column
+type Attachment struct {
+	ID   string
+	Name string
+}
+
+// Attachments returns the files saved for the field, from its form value
+func (f *FormField) Attachments(value string) []Attachment {
+	out := make([]Attachment, 0)
+	if f.attachments == nil {
+		if value != "" {
+			out = append(out, Attachment{Name: "Download " + f.Label})
+		}
+		return out
+	}
+	for _, line := range splitMultiValue(value) {
+		parts := strings.SplitN(line, "\t", 2)
+		if len(parts) == 2 {
+			out = append(out, Attachment{ID: parts[0], Name: parts[1]})
+		}
+	}
+	return out
+}
+
+// MultipleFiles is true if the field can have more than one file
+func (f *FormField) MultipleFiles() bool {
+	return f.attachments != nil
+}
+
+// AcceptTypes is the accept attribute for the field's file input
+func (f *FormField) AcceptTypes() string {
+	return strings.Join(allowedFileTypes, ",")
+}
+
+// HasFiles reports whether the form has any file fields, so needs to be sent as multipart/form-data
+func (f *Form) HasFiles() bool {
+	for _, field := range f.Fields {
+		if field.FieldType == FormFile {
+			return true
+		}
+	}
+	return false
+}
+
+type uploadedFile struct {
+	name        string
+	contentType string
+	content     []byte
+}
+
+// fileChange is what was submitted for a file field, the files uploaded and those to remove
+type fileChange struct {
+	uploads []uploadedFile
+	// the ids of attachments to remove
+	remove []string
+	// remove the file from the field's column
+	clear bool
+}
+
+// changed is false when a file stored in a column is to be left as it is
+func (c *fileChange) changed() bool {
+	return c != nil && (len(c.uploads) > 0 || c.clear)
+}
+
+// columnValue is the value to save for a file stored in a column
+func (c *fileChange) columnValue() interface{} {
+	if c == nil || len(c.uploads) == 0 {
+		return nil
+	}
+	return c.uploads[0].content
+}
+
+// auditValue describes the file uploaded to a column for the audit history, the file itself isn't
+// kept with its name so replacing it is recorded by the new file's name and size
+func (c *fileChange) auditValue() string {
+	if c == nil || len(c.uploads) == 0 {
+		return ""
+	}
+	upload := c.uploads[0]
+	return fmt.Sprintf("%s (%d bytes)", upload.name, len(upload.content))
+}
+
+// detectContentType returns the media type of the file from its contents, what the browser
+// says it is can't be trusted
+func detectContentType(content []byte) string {
+	t, _, err := mime.ParseMediaType(http.DetectContentType(content))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return t
+}
+
+// fileTypeAllowed checks the type against the configured types, which may end in a wildcard e.g. image/*
+func fileTypeAllowed(contentType string) bool {
+	if len(allowedFileTypes) == 0 {
+		return true
+	}
+	for _, allowed := range allowedFileTypes {
+		if allowed == contentType ||
+			(strings.HasSuffix(allowed, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// readUpload reads the uploaded file, checking its size and type. Any error is a message for the user.
+func readUpload(header *multipart.FileHeader) (*uploadedFile, error) {
+	name := filepath.Base(header.Filename)
+	if header.Size > maxFileSize {
+		return nil, errors.Errorf("%s is larger than the %d MB limit", name, maxFileSize>>20)
+	}
+	f, err := header.Open()
+	if err != nil {
+		return nil, errors.Errorf("Unable to read %s", name)
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, errors.Errorf("Unable to read %s", name)
+	}
+	contentType := detectContentType(content)
+	if !fileTypeAllowed(contentType) {
+		return nil, errors.Errorf("%s is not an allowed type of file (%s)", name, contentType)
+	}
+	return &uploadedFile{name: name, contentType: contentType, content: content}, nil
+}
+
+// submittedFiles reads the files uploaded for each file field, adding any problems to errs
+func submittedFiles(frm *Form, req *http.Request, isInsert bool, errs FieldErrors) map[string]*fileChange {
+	out := make(map[string]*fileChange)
+	for _, field := range frm.Fields {
+		if field.FieldType != FormFile {
+			continue
+		}
+		change := &fileChange{}
+		var headers []*multipart.FileHeader
+		if req.MultipartForm != nil {
+			headers = req.MultipartForm.File[field.Name]
+		}
+		if field.isColumn() && len(headers) > 1 {
+			headers = headers[:1]
+		}
+		for _, header := range headers {
+			if header.Filename == "" {
+				continue
+			}
+			upload, err := readUpload(header)
+			if err != nil {
+				errs[field.Name] = err.Error()
+				continue
+			}
+			change.uploads = append(change.uploads, *upload)
+		}
+		if field.isColumn() {
+			change.clear = req.FormValue(field.Name+"-remove") == "1"
+		} else {
+			change.remove = req.Form[field.Name+"-remove"]
+		}
+		// removing every file from a required attachments table is checked by saveRecord, once
+		// they've been removed
+		if _, failed := errs[field.Name]; !failed && field.Required && len(change.uploads) == 0 &&
+			(isInsert || change.clear) {
+			errs[field.Name] = "This field is required"
+		}
+		out[field.Name] = change
+	}
+	return out
+}
+
+// addAttachmentValues loads the names of the files in any attachments tables into the rows
+func addAttachmentValues(ctx context.Context, q queryer, frm *Form, rows []map[string]string) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		args = append(args, row["id"])
+	}
+	for _, field := range frm.Fields {
+		if field.attachments == nil {
+			continue
+		}
+		at := field.attachments
+		query := fmt.Sprintf(
+			"SELECT %s, id, file_name FROM %s WHERE %s IN (%s) ORDER BY id",
+			dialect.CastText(dialect.QuoteIdent(at.parentColumn)),
+			quoteTable(at.table),
+			dialect.QuoteIdent(at.parentColumn),
+			strings.Join(placeholders(1, len(args)), ", "))
+		result, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to query the attachments table %s", at.table))
+		}
+		names := make(map[string][]string)
+		for result.Next() {
+			var parentId, name string
+			var id int
+			if err := result.Scan(&parentId, &id, &name); err != nil {
+				_ = result.Close()
+				return errors.Wrap(err, fmt.Sprintf("unable to read the attachments table %s", at.table))
+			}
+			names[parentId] = append(names[parentId], strconv.Itoa(id)+"\t"+name)
+		}
+		if err := result.Close(); err != nil {
+			return errors.Wrap(err, "unable to close attachment rows")
+		}
+		for _, row := range rows {
+			row[field.Name] = strings.Join(names[row["id"]], multiValueSeparator)
+		}
+	}
+	return nil
+}
+
+// saveAttachments removes and adds the files in the field's attachments table for the record
+func saveAttachments(ctx context.Context, q queryer, field *FormField, parentId int, change *fileChange) error {
+	if change == nil {
+		return nil
+	}
+	at := field.attachments
+	parentCol := dialect.QuoteIdent(at.parentColumn)
+	if len(change.remove) > 0 {
+		args := []interface{}{parentId}
+		for _, id := range change.remove {
+			args = append(args, id)
+		}
+		query := fmt.Sprintf(
+			"DELETE FROM %s WHERE %s = %s AND id IN (%s)",
+			quoteTable(at.table),
+			parentCol,
+			dialect.Placeholder(1),
+			strings.Join(placeholders(2, len(change.remove)), ", "))
+		if _, err := q.ExecContext(ctx, query, args...); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to remove files from %s", at.table))
+		}
+	}
+	query := fmt.Sprintf(
+		"INSERT INTO %s (%s, file_name, content_type, content) VALUES (%s)",
+		quoteTable(at.table),
+		parentCol,
+		strings.Join(placeholders(1, 4), ", "))
+	for _, upload := range change.uploads {
+		if _, err := q.ExecContext(ctx, query, parentId, upload.name, upload.contentType, upload.content); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to save %s to %s", upload.name, at.table))
+		}
+	}
+	return nil
+}
+
+// countAttachments returns the number of files in the field's attachments table for the record
+func countAttachments(ctx context.Context, q queryer, field *FormField, parentId int) (int, error) {
+	at := field.attachments
+	query := fmt.Sprintf(
+		"SELECT COUNT(*) FROM %s WHERE %s = %s",
+		quoteTable(at.table),
+		dialect.QuoteIdent(at.parentColumn),
+		dialect.Placeholder(1))
+	var count int
+	if err := q.QueryRowContext(ctx, query, parentId).Scan(&count); err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("unable to count the files in %s", at.table))
+	}
+	return count, nil
+}
+
+// canAccessRecord applies the same rules as loadFormEntry, admins can see every record and
+// everyone else only the records they created
+func canAccessRecord(ctx context.Context, username string, frm *Form, id int) (bool, error) {
+	query := "SELECT id FROM " + quoteTable(frm.TableName) + " WHERE id = " + dialect.Placeholder(1) +
+		frm.notDeleted("AND")
+	args := []interface{}{id}
+	if isAdmin, _ := frm.Admins[username]; !isAdmin {
+		query += " AND created_user = " + dialect.Placeholder(2)
+		args = append(args, username)
+	}
+	var found int
+	err := db.QueryRowContext(ctx, query, args...).Scan(&found)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "unable to find record")
+	}
+	return true, nil
+}
+
+// loadFile reads a file saved with the record, either from the field's column or its attachments table
+func loadFile(ctx context.Context, frm *Form, field *FormField, id int, attachmentId string) (*uploadedFile, error) {
+	file := &uploadedFile{}
+	var err error
+	if field.attachments == nil {
+		query := fmt.Sprintf(
+			"SELECT %s FROM %s WHERE id = %s",
+			dialect.QuoteIdent(field.Name),
+			quoteTable(frm.TableName),
+			dialect.Placeholder(1))
+		err = db.QueryRowContext(ctx, query, id).Scan(&file.content)
+		file.contentType = detectContentType(file.content)
+		file.name = field.Name
+		if exts, _ := mime.ExtensionsByType(file.contentType); len(exts) > 0 {
+			file.name += exts[0]
+		}
+	} else {
+		at := field.attachments
+		query := fmt.Sprintf(
+			"SELECT file_name, content_type, content FROM %s WHERE id = %s AND %s = %s",
+			quoteTable(at.table),
+			dialect.Placeholder(1),
+			dialect.QuoteIdent(at.parentColumn),
+			dialect.Placeholder(2))
+		err = db.QueryRowContext(ctx, query, attachmentId, id).Scan(&file.name, &file.contentType, &file.content)
+	}
+	if err == sql.ErrNoRows || (err == nil && file.content == nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read file")
+	}
+	return file, nil
+}
+
+// ServeFile downloads a file saved with a record, to those who can see the record
+func ServeFile(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	ctx := req.Context()
+
+	frm, err := forms.get(ctx, vars["table_name"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	username, ok := formUser(w, req, frm)
+	if !ok {
+		return
+	}
+
+	var field *FormField
+	for _, f := range frm.Fields {
+		if f.Name == vars["field"] && f.FieldType == FormFile {
+			field = f
+		}
+	}
+	if field == nil || (field.attachments != nil) != (vars["attachment"] != "") {
+		http.Error(w, "No file field "+vars["field"], http.StatusNotFound)
+		return
+	}
+
+	id, _ := strconv.Atoi(vars["id"])
+	allowed, err := canAccessRecord(ctx, username, frm, id)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "Unable to find record", http.StatusNotFound)
+		return
+	}
+
+	file, err := loadFile(ctx, frm, field, id, vars["attachment"])
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if file == nil {
+		http.Error(w, "Unable to find file", http.StatusNotFound)
+		return
+	}
+
+	// always download rather than display, the file may be html
+	w.Header().Set("Content-Type", file.contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = w.Write(file.content)
+}
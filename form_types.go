@@ -5,6 +5,9 @@ import (
 	"github.com/shopspring/decimal"
 	"html/template"
 	"log"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,92 +19,72 @@ const DateLocal = "2006-01-02"
 type Form struct {
 	Name                     string
 	Description              string
+	Path                     string
 	TableName                string
 	Fields                   []*FormField
 	PreviouslyInsertedRecord string
 	Admins                   map[string]bool
 	AllowAnonymous           bool
 	UseLDAPFields            bool
+	// SoftDelete is set when the table has a deleted_ts column, deleted records are kept but hidden
+	SoftDelete bool
+	// audited is set when the database has an audit table
+	audited bool
+	// fullText is set when the table can be searched with the database's full-text search
+	fullText bool
+	// Problems with the form definition, shown to the admins
+	Problems []string
 }
 
 type FormField struct {
 	Name             string
 	FieldType        FormFieldType
-	Options          []string
+	Options          []FieldOption
 	Required         bool
 	Label            string
 	Description      template.HTML
 	Regex            template.JSStr
+	regex            *regexp.Regexp
+	optionsSpec      string
+	optionsParams    string
+	optionsAsRadio   bool
+	displayColumn    string
+	lookup           *lookupSource
+	multiSelect      bool
+	multiStorage     multiSelectStorage
+	junction         *junctionTable
+	Group            *FieldGroup
+	attachments      *attachmentsTable
 	Placeholder      string
 	SectionHeading   string
 	LinebreakAfter   bool
 	IncludeInSummary bool
 	IsLDAPPopulated  bool
+	MaxLength        int
+	Min              string
+	Max              string
 }
 
 type FormFieldType string
 
 const (
-	FormText      FormFieldType = "text"
-	FormVarChar                 = "varchar"
-	FormInteger                 = "integer"
-	FormDecimal                 = "decimal"
-	FormMoney                   = "money"
-	FormFloat                   = "float"
-	FormBoolean                 = "boolean"
-	FormSelect                  = "select"
-	FormRadio                   = "radio"
-	FormTimeStamp               = "timestamp"
-	FormDate                    = "date"
+	FormText        FormFieldType = "text"
+	FormVarChar                   = "varchar"
+	FormInteger                   = "integer"
+	FormDecimal                   = "decimal"
+	FormMoney                     = "money"
+	FormFloat                     = "float"
+	FormBoolean                   = "boolean"
+	FormSelect                    = "select"
+	FormRadio                     = "radio"
+	FormTimeStamp                 = "timestamp"
+	FormDate                      = "date"
+	FormLookup                    = "lookup"
+	FormMultiSelect               = "multiselect"
+	FormGroup                     = "group"
+	FormFile                      = "file"
 )
 
-func dataTypeToFieldType(dt string) FormFieldType {
-	if dbType == DbPostgres {
-		switch dt {
-		case "character varying":
-			return FormVarChar
-		case "text":
-			return FormText
-		case "integer":
-			return FormInteger
-		case "numeric":
-			return FormDecimal
-		case "money":
-			return FormMoney
-		case "double precision":
-			return FormFloat
-		case "boolean":
-			return FormBoolean
-		case "timestamp with time zone":
-			return FormTimeStamp
-		case "date":
-			return FormDate
-		}
-	} else {
-		switch dt {
-		case "varchar":
-			return FormVarChar
-		case "text":
-			return FormText
-		case "int":
-			return FormInteger
-		case "decimal":
-			return FormDecimal
-		case "money":
-			return FormMoney
-		case "float":
-			return FormFloat
-		case "bit":
-			return FormBoolean
-		case "datetimeoffset":
-			return FormTimeStamp
-		case "date":
-			return FormDate
-		}
-	}
-	return FormVarChar
-}
-
 func emptyFormVal(fieldType FormFieldType) interface{} {
 	switch fieldType {
 	case FormText:
@@ -122,6 +105,12 @@ func emptyFormVal(fieldType FormFieldType) interface{} {
 		return ""
 	case FormRadio:
 		return ""
+	case FormLookup:
+		return ""
+	case FormMultiSelect:
+		return ""
+	case FormFile:
+		return int64(0)
 	case FormTimeStamp:
 		return time.Time{}
 	case FormDate:
@@ -139,36 +128,75 @@ func formValFromInterface(fieldType FormFieldType, valPtr interface{}) string {
 
 	switch fieldType {
 	case FormText:
-		return val.(string)
+		return stringFromInterface(val)
 	case FormVarChar:
-		return val.(string)
+		return stringFromInterface(val)
 	case FormInteger:
-		return fmt.Sprintf("%v", val.(int64))
+		return stringFromInterface(val)
 	case FormDecimal:
-		return string(val.([]uint8))
+		return stringFromInterface(val)
 	case FormMoney:
-		d, err := decimal.NewFromString(string(val.([]uint8)))
+		d, err := decimal.NewFromString(stringFromInterface(val))
 		if err != nil {
 			log.Printf("Error reading decimal: %v", err)
-			return string(val.([]uint8))
+			return stringFromInterface(val)
 		}
 		return d.StringFixed(2)
 	case FormFloat:
-		return fmt.Sprintf("%v", val.(float64))
+		return stringFromInterface(val)
 	case FormSelect:
-		return val.(string)
+		return stringFromInterface(val)
 	case FormRadio:
-		return val.(string)
+		return stringFromInterface(val)
+	case FormLookup:
+		return stringFromInterface(val)
+	case FormMultiSelect:
+		return strings.Join(multiValuesFromInterface(val), multiValueSeparator)
+	case FormFile:
+		// only whether there is a file is read, see selectColumn
+		if stringFromInterface(val) != "0" {
+			return "1"
+		}
+		return ""
 	case FormTimeStamp:
 		return val.(time.Time).Format(DateTimeLocal)
 	case FormDate:
 		return val.(time.Time).Format(DateLocal)
 	case FormBoolean:
-		if val.(bool) {
-			return "1"
-		} else {
-			return ""
+		// mysql has no real boolean, tinyint(1) comes back as an integer
+		switch b := val.(type) {
+		case bool:
+			if b {
+				return "1"
+			}
+		case int64:
+			if b != 0 {
+				return "1"
+			}
+		case []uint8:
+			if string(b) != "0" && string(b) != "" {
+				return "1"
+			}
 		}
+		return ""
 	}
 	return ""
 }
+
+// stringFromInterface formats a scanned value, drivers differ on whether text and
+// numeric types are returned as strings, byte slices or native types
+func stringFromInterface(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case []uint8:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return fmt.Sprintf("%v", v)
+	}
+	return fmt.Sprintf("%v", val)
+}
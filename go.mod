@@ -1,17 +1,35 @@
 module sql-form
 
-go 1.13
+go 1.25.0
 
 require (
 	github.com/BurntSushi/toml v0.3.1
+	github.com/coreos/go-oidc/v3 v3.21.0
 	github.com/denisenkom/go-mssqldb v0.0.0-20200206145737-bbfc9a55622e
+	github.com/go-jose/go-jose/v4 v4.1.4
 	github.com/go-ldap/ldap/v3 v3.1.7
+	github.com/go-sql-driver/mysql v1.5.0
 	github.com/gomarkdown/markdown v0.0.0-20200127000047-1813ea067497
 	github.com/gorilla/mux v1.7.4
 	github.com/gorilla/sessions v1.2.0
 	github.com/jcmturner/goidentity/v6 v6.0.1
 	github.com/jcmturner/gokrb5/v8 v8.1.1
 	github.com/lib/pq v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.0
 	github.com/pkg/errors v0.9.1
 	github.com/shopspring/decimal v0.0.0-20200226005030-a08b92da27ed
+	golang.org/x/oauth2 v0.36.0
+)
+
+require (
+	github.com/go-asn1-ber/asn1-ber v1.3.1 // indirect
+	github.com/golang-sql/civil v0.0.0-20190719163853-cb61b32ac6fe // indirect
+	github.com/gorilla/securecookie v1.1.1 // indirect
+	github.com/hashicorp/go-uuid v1.0.2 // indirect
+	github.com/jcmturner/aescts/v2 v2.0.0 // indirect
+	github.com/jcmturner/dnsutils/v2 v2.0.0 // indirect
+	github.com/jcmturner/gofork v1.0.0 // indirect
+	github.com/jcmturner/rpc/v2 v2.0.2 // indirect
+	golang.org/x/crypto v0.0.0-20200117160349-530e935923ad // indirect
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e // indirect
 )
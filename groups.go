@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FieldGroup is a repeating group of fields, e.g. the lines of an expense claim. Each row is saved
+// in a child table with a foreign key to the form's table.
+type FieldGroup struct {
+	Fields       []*FormField
+	table        string
+	parentColumn string
+}
+
+// groupRows holds the rows of each of the form's groups, keyed by the group's field name
+type groupRows map[string][]map[string]string
+
+// groupRow is a validated row of a group, with the values to save in the order of the group's fields
+type groupRow struct {
+	id     string
+	values []interface{}
+}
+
+var childTableSpec = regexp.MustCompile(`^\s*([\w.]+)\s*\(\s*(\w+)\s*\)\s*$`)
+
+// tableExists checks for the table by looking for its columns
+func tableExists(ctx context.Context, tableName string) (bool, error) {
+	schema, table := splitTableName(tableName)
+	cols, err := dialect.TableColumns(ctx, schema, table, 0)
+	if err != nil {
+		return false, err
+	}
+	return len(cols) > 0, nil
+}
+
+// loadFieldGroup reads the child table given in a child_table label, in the format
+// table(parent_id_column). The child table has an id column, the parent id column, then the
+// fields, labelled by its own optional _labels table.
+func loadFieldGroup(ctx context.Context, spec string, problem func(error)) (*FieldGroup, error) {
+	m := childTableSpec.FindStringSubmatch(spec)
+	if m == nil {
+		return nil, errors.Errorf("child table %q is not in the format table(parent_id_column)", spec)
+	}
+	group := &FieldGroup{table: m[1], parentColumn: m[2]}
+
+	schema, table := splitTableName(group.table)
+	cols, err := dialect.TableColumns(ctx, schema, table, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) == 0 {
+		return nil, errors.Errorf("child table %s does not exist", group.table)
+	}
+
+	labels := make(map[string]*fieldLabels)
+	if exists, err := tableExists(ctx, group.table+"_labels"); err != nil {
+		return nil, err
+	} else if exists {
+		if labels, err = loadFieldLabels(ctx, group.table); err != nil {
+			return nil, err
+		}
+	}
+
+	foreignKeys := make(map[string]*foreignKey)
+	fks, err := dialect.ForeignKeys(ctx, schema, table)
+	if err != nil {
+		problem(err)
+	}
+	for _, fk := range fks {
+		foreignKeys[fk.column] = fk
+	}
+
+	hasParent := false
+	for _, col := range cols {
+		if col.name == "id" {
+			continue
+		}
+		if col.name == group.parentColumn {
+			hasParent = true
+			continue
+		}
+		field := loadField(col, labels)
+		if field.FieldType == FormFile {
+			problem(errors.Errorf("%s of %s is a file, files can't be added to rows", col.name, group.table))
+			continue
+		}
+		resolveField(ctx, field, foreignKeys[field.Name], problem)
+		group.Fields = append(group.Fields, field)
+	}
+	if !hasParent {
+		return nil, errors.Errorf("child table %s has no column %s", group.table, group.parentColumn)
+	}
+	return group, nil
+}
+
+// groupInputName is the name of the input for a field in a row of a group
+func groupInputName(group string, index interface{}, field string) string {
+	return fmt.Sprintf("%s-%v-%s", group, index, field)
+}
+
+// GroupCell is the input for one of the fields in a row of a group
+type GroupCell struct {
+	Field *FormField
+	Name  string
+	Value string
+	Error string
+}
+
+// GroupRowInputs are the inputs for a row of a group
+type GroupRowInputs struct {
+	IDName string
+	ID     string
+	Cells  []GroupCell
+}
+
+// RowInputs returns the inputs for a row of the group. The index is the row's position, or a
+// placeholder for the blank row that's copied when a row is added.
+func (f *FormField) RowInputs(index interface{}, row map[string]string, errs FieldErrors) *GroupRowInputs {
+	inputs := &GroupRowInputs{IDName: groupInputName(f.Name, index, "id"), ID: row["id"]}
+	for _, field := range f.Group.Fields {
+		name := groupInputName(f.Name, index, field.Name)
+		inputs.Cells = append(inputs.Cells, GroupCell{
+			Field: field,
+			Name:  name,
+			Value: row[field.Name],
+			Error: errs[name],
+		})
+	}
+	return inputs
+}
+
+// submittedGroupRows reads the rows of each group from the request. Each row has an id input,
+// blank for new rows, and the rows are numbered from zero in the order they were shown.
+func submittedGroupRows(frm *Form, req *http.Request) groupRows {
+	out := make(groupRows)
+	for _, field := range frm.Fields {
+		if field.Group == nil {
+			continue
+		}
+		indexes := make([]int, 0)
+		prefix := field.Name + "-"
+		for name := range req.Form {
+			if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, "-id") {
+				continue
+			}
+			if i, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, prefix), "-id")); err == nil {
+				indexes = append(indexes, i)
+			}
+		}
+		sort.Ints(indexes)
+		rows := make([]map[string]string, 0, len(indexes))
+		for _, i := range indexes {
+			row := map[string]string{"id": req.Form.Get(groupInputName(field.Name, i, "id"))}
+			for _, child := range field.Group.Fields {
+				name := groupInputName(field.Name, i, child.Name)
+				if child.FieldType == FormMultiSelect {
+					row[child.Name] = strings.Join(req.Form[name], multiValueSeparator)
+				} else {
+					row[child.Name] = req.Form.Get(name)
+				}
+			}
+			rows = append(rows, row)
+		}
+		out[field.Name] = rows
+	}
+	return out
+}
+
+// validateGroupRows checks each of the rows of the group, adding any problems to errs keyed by
+// the input name
+func validateGroupRows(ctx context.Context, field *FormField, rows []map[string]string, tzOffset string, errs FieldErrors) ([]groupRow, error) {
+	out := make([]groupRow, 0, len(rows))
+	for i, row := range rows {
+		parsed := groupRow{id: row["id"]}
+		for _, child := range field.Group.Fields {
+			val, msg, err := checkFieldValue(ctx, child, row[child.Name], tzOffset)
+			if err != nil {
+				return nil, err
+			}
+			if msg != "" {
+				errs[groupInputName(field.Name, i, child.Name)] = msg
+				continue
+			}
+			if selected, ok := val.([]string); ok {
+				val = multiSelectColumnValue(child, selected)
+			}
+			parsed.values = append(parsed.values, val)
+		}
+		out = append(out, parsed)
+	}
+	return out, nil
+}
+
+// loadGroupRows reads the rows of each of the form's groups for the record
+func loadGroupRows(ctx context.Context, q queryer, frm *Form, id int) (groupRows, error) {
+	idStr := strconv.Itoa(id)
+	out, err := loadRecordsGroupRows(ctx, q, frm, []string{idStr})
+	if err != nil {
+		return nil, err
+	}
+	return out[idStr], nil
+}
+
+// loadRecordsGroupRows reads the rows of each of the form's groups for each of the given record
+// ids, keyed by id
+func loadRecordsGroupRows(ctx context.Context, q queryer, frm *Form, ids []string) (map[string]groupRows, error) {
+	out := make(map[string]groupRows)
+	for _, id := range ids {
+		out[id] = make(groupRows)
+	}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	for _, field := range frm.Fields {
+		if field.Group == nil {
+			continue
+		}
+		group := field.Group
+		cols := []string{dialect.CastText(dialect.QuoteIdent(group.parentColumn)), "id"}
+		for _, child := range group.Fields {
+			cols = append(cols, selectColumn(child.Name, child.FieldType))
+		}
+		query := fmt.Sprintf(
+			"SELECT %s FROM %s WHERE %s IN (%s) ORDER BY id",
+			strings.Join(cols, ","),
+			quoteTable(group.table),
+			dialect.QuoteIdent(group.parentColumn),
+			strings.Join(placeholders(1, len(ids)), ", "))
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("unable to query the child table %s", group.table))
+		}
+		for _, id := range ids {
+			out[id][field.Name] = make([]map[string]string, 0)
+		}
+		for rows.Next() {
+			var parentId string
+			vals := make([]interface{}, 0, len(cols))
+			vals = append(vals, &parentId)
+			var valId interface{} = 0
+			vals = append(vals, &valId)
+			for _, child := range group.Fields {
+				val := emptyFormVal(child.FieldType)
+				vals = append(vals, &val)
+			}
+			if err := rows.Scan(vals...); err != nil {
+				_ = rows.Close()
+				return nil, errors.Wrap(err, fmt.Sprintf("unable to read the child table %s", group.table))
+			}
+			row := map[string]string{"id": formValFromInterface(FormInteger, vals[1])}
+			for i, child := range group.Fields {
+				row[child.Name] = formValFromInterface(child.FieldType, vals[i+2])
+			}
+			if recordRows, exists := out[parentId]; exists {
+				recordRows[field.Name] = append(recordRows[field.Name], row)
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return nil, errors.Wrap(err, "unable to close child table rows")
+		}
+	}
+	return out, nil
+}
+
+// saveGroupRows saves the rows of the group for the record: rows without an id are inserted,
+// the others updated, and any rows that were removed are deleted
+func saveGroupRows(ctx context.Context, q queryer, field *FormField, parentId int, rows []groupRow) error {
+	group := field.Group
+	parentCol := dialect.QuoteIdent(group.parentColumn)
+
+	keep := make([]interface{}, 0, len(rows))
+	keep = append(keep, parentId)
+	for _, row := range rows {
+		if row.id != "" {
+			keep = append(keep, row.id)
+		}
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = %s", quoteTable(group.table), parentCol, dialect.Placeholder(1))
+	if len(keep) > 1 {
+		query += fmt.Sprintf(" AND id NOT IN (%s)", strings.Join(placeholders(2, len(keep)-1), ", "))
+	}
+	if _, err := q.ExecContext(ctx, query, keep...); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to remove rows from %s", group.table))
+	}
+
+	names := make([]string, 0, len(group.Fields))
+	set := make([]string, 0, len(group.Fields))
+	for i, child := range group.Fields {
+		names = append(names, dialect.QuoteIdent(child.Name))
+		set = append(set, dialect.QuoteIdent(child.Name)+" = "+dialect.Placeholder(i+1))
+	}
+	n := len(group.Fields)
+	insert := fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (%s)",
+		quoteTable(group.table),
+		strings.Join(append(names, parentCol), ", "),
+		strings.Join(placeholders(1, n+1), ", "))
+	update := fmt.Sprintf(
+		"UPDATE %s SET %s WHERE id = %s AND %s = %s",
+		quoteTable(group.table),
+		strings.Join(set, ", "),
+		dialect.Placeholder(n+1),
+		parentCol,
+		dialect.Placeholder(n+2))
+
+	for _, row := range rows {
+		if row.id == "" {
+			if _, err := q.ExecContext(ctx, insert, append(row.values, parentId)...); err != nil {
+				return errors.Wrap(err, fmt.Sprintf("unable to add a row to %s", group.table))
+			}
+			continue
+		}
+		res, err := q.ExecContext(ctx, update, append(row.values, row.id, parentId)...)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to update a row of %s", group.table))
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return errors.Wrap(err, "unable to determine updated rows")
+		}
+		if affected == 0 {
+			return errors.Errorf("Unable to find row %s of %s", row.id, group.table)
+		}
+	}
+	return nil
+}
@@ -1,17 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"fmt"
+	"database/sql"
 	"github.com/gorilla/mux"
 	"github.com/jcmturner/goidentity/v6"
 	"github.com/pkg/errors"
-	"github.com/shopspring/decimal"
 	"html/template"
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
 )
 
 var formTemplate *template.Template
@@ -19,20 +18,30 @@ var listTemplate *template.Template
 
 func saveFormSubmission(ctx context.Context, username string, frm *Form, req *http.Request) (int, error) {
 
-	values := make([]interface{}, 0, len(frm.Fields)+1)
+	vals, rows := submittedValues(frm, req)
+	isInsert := vals["id"] == ""
 
-	query := ""
-	isInsert := req.FormValue("id") == ""
-	if isInsert {
-		query = generateInsertStatement(frm.TableName, frm.Fields)
-		// log.Println(query)
-	} else {
-		isAdmin, _ := frm.Admins[username]
-		query = generateUpdateStatement(frm.TableName, isAdmin, frm.Fields)
-		values = append(values, req.FormValue("id"))
-		// log.Println("query", query)
+	fileErrs := make(FieldErrors)
+	files := submittedFiles(frm, req, isInsert, fileErrs)
+	parsed, err := validateSubmission(ctx, frm, vals, rows)
+	if verr, ok := err.(*ValidationError); ok {
+		for name, msg := range fileErrs {
+			verr.Fields[name] = msg
+		}
+		return 0, verr
 	}
+	if err != nil {
+		return 0, err
+	}
+	if len(fileErrs) > 0 {
+		return 0, &ValidationError{Fields: fileErrs}
+	}
+	return commitSubmission(ctx, username, frm, vals, parsed, files)
+}
 
+// commitSubmission saves the record from its values validated by validateSubmission, with any LDAP
+// fields for the user, in a transaction
+func commitSubmission(ctx context.Context, username string, frm *Form, vals map[string]string, parsed map[string]interface{}, files map[string]*fileChange) (int, error) {
 	ldapValues := make(map[string]string)
 	if frm.UseLDAPFields {
 		var err error
@@ -42,80 +51,146 @@ func saveFormSubmission(ctx context.Context, username string, frm *Form, req *ht
 		}
 	}
 
-	values = append(values, username)
+	// the record and any junction, child or attachments table rows are saved together
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to start transaction")
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	id, err := saveRecord(ctx, tx, username, frm, vals, parsed, files, ldapValues)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "unable to commit")
+	}
+	return id, nil
+}
+
+// saveRecord inserts the record, or updates it if vals has an id, from the values returned by
+// validateSubmission, along with its rows in any junction, child and attachments tables
+func saveRecord(ctx context.Context, tx *sql.Tx, username string, frm *Form, vals map[string]string, parsed map[string]interface{}, files map[string]*fileChange, ldapValues map[string]string) (int, error) {
+	isInsert := vals["id"] == ""
+	values := make([]interface{}, 0, len(frm.Fields)+3)
+
+	// files in columns are only updated when a new one is uploaded or the old one removed
+	fields := make([]*FormField, 0, len(frm.Fields))
 	for _, field := range frm.Fields {
-		var val interface{}
-		var err error
+		if !isInsert && field.FieldType == FormFile && field.isColumn() && !files[field.Name].changed() {
+			continue
+		}
+		fields = append(fields, field)
+	}
 
-		// ldap fields first, if it's an insert
-		if field.IsLDAPPopulated && isInsert {
-			val = ldapValues[field.Name]
-			values = append(values, val)
+	junctionValues := make(map[*FormField][]string)
+	for _, field := range fields {
+		if field.IsLDAPPopulated {
+			// ldap fields are only set on insert, they cannot be updated.
+			if isInsert {
+				values = append(values, ldapValues[field.Name])
+			}
+			continue
+		}
+		if field.Group != nil {
+			continue
+		}
+		if field.FieldType == FormFile {
+			if field.isColumn() {
+				values = append(values, files[field.Name].columnValue())
+			}
+			continue
+		}
+		if field.FieldType == FormMultiSelect {
+			selected, _ := parsed[field.Name].([]string)
+			if !field.isColumn() {
+				junctionValues[field] = selected
+				continue
+			}
+			values = append(values, multiSelectColumnValue(field, selected))
 			continue
 		}
+		values = append(values, parsed[field.Name])
+	}
 
-		switch field.FieldType {
-		case FormInteger:
-			if !field.Required && req.FormValue(field.Name) == "" {
-				val = nil
-			} else {
-				val, err = strconv.Atoi(req.FormValue(field.Name))
-				if err != nil {
-					return 0, errors.Wrap(err, fmt.Sprintf("unable to parse as int %s: %s", field.Name, req.FormValue(field.Name)))
-				}
+	// the record as it was, for the audit history
+	var err error
+	before, beforeRows := map[string]string{}, groupRows{}
+	if !isInsert && frm.audited {
+		beforeId, _ := strconv.Atoi(vals["id"])
+		if before, beforeRows, err = loadEntry(ctx, tx, username, beforeId, frm); err != nil {
+			return 0, err
+		}
+	}
+
+	var id int
+	action := "insert"
+	if isInsert {
+		query := generateInsertStatement(frm.TableName, fields)
+		values = append([]interface{}{username}, values...)
+		id, err = dialect.InsertReturningID(ctx, tx, query, values...)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		action = "update"
+		isAdmin, _ := frm.Admins[username]
+		query := generateUpdateStatement(frm.TableName, isAdmin, fields) + frm.notDeleted("AND")
+		values = append(values, vals["id"], username, vals["updated_ts"])
+		res, err := tx.ExecContext(ctx, query, values...)
+		if err != nil {
+			return 0, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, errors.Wrap(err, "unable to determine updated rows")
+		}
+		if affected == 0 {
+			return 0, updateFailure(ctx, tx, username, frm, vals)
+		}
+		if id, err = strconv.Atoi(vals["id"]); err != nil {
+			return 0, err
+		}
+	}
+
+	for field, selected := range junctionValues {
+		if err := saveJunctionValues(ctx, tx, field, id, selected); err != nil {
+			return 0, err
+		}
+	}
+	for _, field := range frm.Fields {
+		if field.Group != nil {
+			parsedRows, _ := parsed[field.Name].([]groupRow)
+			if err := saveGroupRows(ctx, tx, field, id, parsedRows); err != nil {
+				return 0, err
 			}
-		case FormDecimal:
-			if !field.Required && req.FormValue(field.Name) == "" {
-				val = nil
-			} else {
-				val, err = decimal.NewFromString(req.FormValue(field.Name))
-				if err != nil {
-					return 0, errors.Wrap(err, fmt.Sprintf("unable to parse as decimal %s: %s", field.Name, req.FormValue(field.Name)))
-				}
+		}
+		if field.attachments != nil {
+			if err := saveAttachments(ctx, tx, field, id, files[field.Name]); err != nil {
+				return 0, err
 			}
-		case FormMoney:
-			if !field.Required && req.FormValue(field.Name) == "" {
-				val = nil
-			} else {
-				val, err = decimal.NewFromString(req.FormValue(field.Name))
+			if change := files[field.Name]; field.Required && change != nil && len(change.remove) > 0 && len(change.uploads) == 0 {
+				count, err := countAttachments(ctx, tx, field, id)
 				if err != nil {
-					return 0, errors.Wrap(err, fmt.Sprintf("unable to parse as money %s: %s", field.Name, req.FormValue(field.Name)))
+					return 0, err
 				}
-			}
-		case FormFloat:
-			if !field.Required && req.FormValue(field.Name) == "" {
-				val = nil
-			} else {
-				val, err = strconv.ParseFloat(req.FormValue(field.Name), 64)
-				if err != nil {
-					return 0, errors.Wrap(err, fmt.Sprintf("unable to parse as float %s: %s", field.Name, req.FormValue(field.Name)))
+				if count == 0 {
+					return 0, &ValidationError{Fields: FieldErrors{field.Name: "This field is required"}}
 				}
 			}
-		// bools can't be not null
-		case FormBoolean:
-			val = req.FormValue(field.Name) == "1"
-		case FormTimeStamp:
-			if !field.Required && req.FormValue(field.Name) == "" {
-				val = nil
-			} else {
-				tzOffset := minOffsetToTZOffset(req.FormValue("timezone-offset"))
-				val = strings.ReplaceAll(req.FormValue(field.Name), "T", " ") + tzOffset
-			}
-		default:
-			if !field.Required && req.FormValue(field.Name) == "" {
-				val = nil
-			} else {
-				val = req.FormValue(field.Name)
-			}
 		}
-		values = append(values, val)
 	}
-	insertId := 0
-	err := db.QueryRowContext(ctx, query, values...).Scan(&insertId)
-	if err != nil {
-		return 0, err
+	if frm.audited {
+		after, afterRows, err := loadEntry(ctx, tx, username, id, frm)
+		if err != nil {
+			return 0, err
+		}
+		changes := auditChanges(frm, before, beforeRows, after, afterRows, files)
+		if err := recordAudit(ctx, tx, frm, id, username, action, changes); err != nil {
+			return 0, err
+		}
 	}
-	return insertId, nil
+	return id, nil
 }
 
 func ServeForm(w http.ResponseWriter, req *http.Request) {
@@ -134,7 +209,7 @@ func ServeForm(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 
 	var frm *Form
-	if frm, err = loadForm(ctx, formPath); err != nil {
+	if frm, err = forms.get(ctx, formPath); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -145,32 +220,17 @@ func ServeForm(w http.ResponseWriter, req *http.Request) {
 		http.SetCookie(w, &cookie)
 	}
 
-	creds := goidentity.FromHTTPRequestContext(req)
-	username := "anonymous"
-	if creds != nil {
-		username = creds.UserName()
-	} else if !frm.AllowAnonymous {
-		http.Error(
-			w,
-			"Check active directory integration - unable to determine logged in user",
-			http.StatusUnauthorized,
-		)
+	username, ok := formUser(w, req, frm)
+	if !ok {
 		return
 	}
 
 	if req.Method == http.MethodGet {
 
-		// TODO: this is in every request for development purposes, can remove when done.
-		formTemplate, err = template.ParseFiles("index.template.html")
-		if err != nil {
-			log.Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
 		vals := map[string]string{}
+		rows := groupRows{}
 		if entryId > 0 {
-			vals, err = loadFormEntry(ctx, username, entryId, frm)
+			vals, rows, err = loadFormEntry(ctx, username, entryId, frm)
 			if err != nil {
 				log.Println(err)
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -179,15 +239,26 @@ func ServeForm(w http.ResponseWriter, req *http.Request) {
 			vals["id"] = entryIdStr
 		}
 
-		err = formTemplate.Execute(w, map[string]interface{}{"frm": frm, "vals": vals, "username": username})
-		if err != nil {
-			log.Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		renderForm(w, req, http.StatusOK, frm, vals, rows, FieldErrors{}, username)
+	} else if req.Method == http.MethodPost {
+		req.Body = http.MaxBytesReader(w, req.Body, maxRequestSize)
+		if err := req.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+			http.Error(w, "Unable to read the submission: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-	} else if req.Method == http.MethodPost {
 
 		insertedId, err := saveFormSubmission(ctx, username, frm, req)
+		if verr, ok := err.(*ValidationError); ok {
+			// show the form again with what they entered, and what's wrong with it
+			vals, rows := submittedValues(frm, req)
+			renderForm(w, req, http.StatusUnprocessableEntity, frm, vals, rows, verr.Fields, username)
+			return
+		}
+		if conflict, ok := err.(*ConflictError); ok {
+			vals, rows := submittedValues(frm, req)
+			renderConflict(w, frm, vals, rows, conflict, username)
+			return
+		}
 		if err != nil {
 			log.Println(err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -200,6 +271,36 @@ func ServeForm(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// renderForm shows the form template with the given values and any errors in them, and the
+// history of the record being edited
+func renderForm(w http.ResponseWriter, req *http.Request, status int, frm *Form, vals map[string]string, rows groupRows, errs FieldErrors, username string) {
+	var err error
+
+	var history []AuditEntry
+	if id, _ := strconv.Atoi(vals["id"]); id > 0 {
+		if history, err = loadAuditHistory(req.Context(), frm, id); err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	isAdmin, _ := frm.Admins[username]
+	err = formTemplate.Execute(buf, map[string]interface{}{
+		"frm": frm, "vals": vals, "rows": rows, "errors": errs, "username": username, "isAdmin": isAdmin,
+		"history": history,
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = buf.WriteTo(w)
+}
+
 func ServeFormListEntries(w http.ResponseWriter, req *http.Request) {
 	var err error
 
@@ -213,43 +314,102 @@ func ServeFormListEntries(w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 
 	var frm *Form
-	if frm, err = loadForm(ctx, formPath); err != nil {
+	if frm, err = forms.get(ctx, formPath); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	creds := goidentity.FromHTTPRequestContext(req)
-	username := "anonymous"
-	if creds != nil {
-		username = creds.UserName()
-	} else if !frm.AllowAnonymous {
-		http.Error(
-			w,
-			"Check active directory integration - unable to determine logged in user",
-			http.StatusUnauthorized,
-		)
+	username, ok := formUser(w, req, frm)
+	if !ok {
 		return
 	}
 
 	// we are requesting a list of submissions for this user
-	listTemplate, err = template.ParseFiles("list.template.html")
+	list := parseListQuery(frm, req)
+	vals, err := loadFormList(ctx, username, frm, list, true)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	vals, err := loadFormList(ctx, username, frm)
+	isAdmin, _ := frm.Admins[username]
+	err = listTemplate.Execute(w, map[string]interface{}{
+		"frm": frm, "vals": vals, "username": username, "isAdmin": isAdmin, "list": list,
+	})
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	err = listTemplate.Execute(w, map[string]interface{}{"frm": frm, "vals": vals, "username": username})
+}
+
+// ServeFormReload drops the cached definition of the form, so changes to the table or labels show up
+// straight away. Only admins of the form can reload it.
+func ServeFormReload(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	formPath, exists := vars["table_name"]
+	if !exists {
+		http.Error(w, "Check form path", http.StatusNotFound)
+		return
+	}
+
+	// load it fresh, the admins may be what has changed
+	frm, err := loadForm(req.Context(), formPath)
 	if err != nil {
-		log.Println(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+
+	username, ok := formUser(w, req, frm)
+	if !ok {
+		return
+	}
+	if isAdmin, _ := frm.Admins[username]; !isAdmin {
+		http.Error(w, "Only form admins can reload the form", http.StatusForbidden)
+		return
+	}
+
+	forms.invalidate(formPath)
+	forms.put(frm)
+	http.Redirect(w, req, "/"+formPath+"/list", http.StatusSeeOther)
+}
+
+// formUser determines the logged in user, writing an error and returning false if there isn't
+// one and the form doesn't allow anonymous submissions
+func formUser(w http.ResponseWriter, req *http.Request, frm *Form) (string, bool) {
+	creds := goidentity.FromHTTPRequestContext(req)
+	if creds != nil {
+		return creds.UserName(), true
+	}
+	if !frm.AllowAnonymous {
+		http.Error(
+			w,
+			"Check active directory integration - unable to determine logged in user",
+			http.StatusUnauthorized,
+		)
+		return "", false
+	}
+	return "anonymous", true
+}
+
+// userForms are the forms the user can use, all of them once they're logged in, otherwise those that
+// allow anonymous users, along with their username
+func userForms(ctx context.Context, req *http.Request) ([]*Form, string, error) {
+	catalogue, err := loadFormCatalogue(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	creds := goidentity.FromHTTPRequestContext(req)
+	if creds != nil {
+		return catalogue, creds.UserName(), nil
+	}
+	out := make([]*Form, 0, len(catalogue))
+	for _, frm := range catalogue {
+		if frm.AllowAnonymous {
+			out = append(out, frm)
+		}
+	}
+	return out, "anonymous", nil
 }
 
 func parseTemplates(conf tomlConfig) {
@@ -262,28 +422,77 @@ func parseTemplates(conf tomlConfig) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	deleteTemplate, err = template.ParseFiles("delete.template.html")
+	if err != nil {
+		log.Fatal(err)
+	}
+	conflictTemplate, err = template.ParseFiles("conflict.template.html")
+	if err != nil {
+		log.Fatal(err)
+	}
+	importTemplate, err = template.ParseFiles("import.template.html")
+	if err != nil {
+		log.Fatal(err)
+	}
+	homeTemplate, err = template.ParseFiles("home.template.html")
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func serve(conf tomlConfig) {
 	var err error
 	parseTemplates(conf)
+	setupFormCache(conf)
+	setupFiles(conf)
 	// start listening
 	r := mux.NewRouter()
 	r.PathPrefix("/static/").Handler(
 		http.StripPrefix("/static/", http.FileServer(http.Dir(conf.Server.StaticDir))),
 	)
+	var oidc *oidcMiddleware
+	if conf.Auth.OIDCIssuer != "" {
+		if conf.Auth.Keytab != "" {
+			log.Fatal("set either keytab or oidcIssuer in [auth], not both")
+		}
+		if oidc, err = newOIDCMiddleware(conf.Auth, conf.Server.Certificate != ""); err != nil {
+			log.Fatal(err)
+		}
+		r.HandleFunc("/auth/login", oidc.ServeLogin).Methods(http.MethodGet)
+		r.HandleFunc("/auth/callback", oidc.ServeCallback).Methods(http.MethodGet)
+		r.HandleFunc("/auth/logout", oidc.ServeLogout)
+		logoutURL = "/auth/logout"
+	}
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/openapi.json", ServeOpenAPI).Methods(http.MethodGet)
+	api.HandleFunc("/forms/{table_name}", ServeAPIForm).Methods(http.MethodGet)
+	api.HandleFunc("/forms/{table_name}/schema.json", ServeAPISchema).Methods(http.MethodGet)
+	api.HandleFunc("/forms/{table_name}/records", ServeAPIRecords).Methods(http.MethodGet, http.MethodPost)
+	api.HandleFunc("/forms/{table_name}/records/{id:[0-9]+}", ServeAPIRecord).
+		Methods(http.MethodGet, http.MethodPut, http.MethodDelete)
 	r.HandleFunc("/{table_name}/edit/{id:[0-9]+}", ServeForm)
+	r.HandleFunc("/{table_name}/delete/{id:[0-9]+}", ServeDelete).Methods(http.MethodGet, http.MethodPost)
 	r.HandleFunc("/{table_name}/list", ServeFormListEntries)
+	r.HandleFunc("/{table_name}/export.csv", ServeExportCSV)
+	r.HandleFunc("/{table_name}/export.xlsx", ServeExportXLSX)
+	r.HandleFunc("/{table_name}/reload", ServeFormReload).Methods(http.MethodPost)
+	r.HandleFunc("/{table_name}/import", ServeImport).Methods(http.MethodGet, http.MethodPost)
+	r.HandleFunc("/{table_name}/lookup/{field}", ServeLookup)
+	r.HandleFunc("/{table_name}/file/{id:[0-9]+}/{field}", ServeFile)
+	r.HandleFunc("/{table_name}/file/{id:[0-9]+}/{field}/{attachment:[0-9]+}", ServeFile)
 	r.HandleFunc("/{table_name}", ServeForm)
-	r.HandleFunc("/", ServeForm)
+	r.HandleFunc("/", ServeHome)
 
 	if conf.Auth.Keytab != "" {
-		sm, err := spnegoFromKeytab(conf.Auth.Keytab, conf.Auth.SessionKey, conf.Auth.CookieName)
+		sm, err := spnegoFromKeytab(conf.Auth.Keytab, conf.Auth.SessionKey, conf.Auth.CookieName, conf.Server.Certificate != "")
 		if err != nil {
 			log.Fatal(err)
 		}
 		r.Use(sm.Middleware)
 	}
+	if oidc != nil {
+		r.Use(oidc.Middleware)
+	}
 
 	if conf.Server.Certificate != "" {
 		log.Print("Starting TLS (https) server, listening on " + conf.Server.Listen)
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+var homeTemplate *template.Template
+
+// ServeHome lists the forms the user can use, with links to a new submission and the list of them
+func ServeHome(w http.ResponseWriter, req *http.Request) {
+	catalogue, username, err := userForms(req.Context(), req)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	err = homeTemplate.Execute(buf, map[string]interface{}{
+		"forms":    catalogue,
+		"username": username,
+		"logout":   logoutURL,
+	})
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = buf.WriteTo(w)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var importTemplate *template.Template
+
+// importIgnoredHeaders are the columns of an export that aren't fields, so an export can be imported
+var importIgnoredHeaders = map[string]bool{
+	"#": true, "id": true, "user": true, "created_user": true, "submitted": true, "created_ts": true,
+}
+
+// ImportRowError is what's wrong with a row of an import, Row is the number of the row in the csv
+// file counting the headers as row 1, as a spreadsheet would show it
+type ImportRowError struct {
+	Row    int
+	Errors []string
+}
+
+// ImportError is returned when the csv can't be imported, nothing is saved if there are any problems
+type ImportError struct {
+	// problems with the file as a whole, e.g. a column that isn't a field
+	Problems []string
+	Rows     []ImportRowError
+}
+
+func (e *ImportError) Error() string {
+	msgs := append([]string{}, e.Problems...)
+	for _, row := range e.Rows {
+		msgs = append(msgs, fmt.Sprintf("row %d: %s", row.Row, strings.Join(row.Errors, ", ")))
+	}
+	return "unable to import: " + strings.Join(msgs, "; ")
+}
+
+// importColumns matches the headers of the csv to the form's fields by name or label, ignoring case.
+// The values of groups and files can't be imported.
+func importColumns(frm *Form, headers []string) ([]*FormField, []string) {
+	fields := make([]*FormField, len(headers))
+	problems := make([]string, 0)
+	for i, header := range headers {
+		header = strings.TrimSpace(strings.TrimPrefix(header, "\ufeff"))
+		for _, field := range frm.Fields {
+			if strings.EqualFold(header, field.Name) || strings.EqualFold(header, field.Label) {
+				fields[i] = field
+				break
+			}
+		}
+		switch {
+		case fields[i] == nil && importIgnoredHeaders[strings.ToLower(header)]:
+		case fields[i] == nil:
+			problems = append(problems, fmt.Sprintf("column %q is not a field of the form", header))
+		case fields[i].Group != nil || fields[i].FieldType == FormFile:
+			problems = append(problems, fmt.Sprintf("column %q can't be imported", header))
+		}
+	}
+	return fields, problems
+}
+
+// importTimestamp matches timestamps written with a space rather than a T, e.g. from a spreadsheet
+var importTimestamp = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2}) (\d{2}:\d{2}(:\d{2})?)$`)
+
+// importValue converts a cell of the csv to the form value of the field. Checkboxes can be given as
+// 1, true, yes, y or x, and options by their value or label. Multi-selects can be delimited with new
+// lines (as they're exported) or semicolons. The ' an export puts before values that look like
+// formulas is removed.
+func importValue(field *FormField, cell string) string {
+	cell = strings.TrimSpace(cell)
+	if strings.HasPrefix(cell, "'") && csvCell(cell[1:]) == cell {
+		cell = cell[1:]
+	}
+	switch field.FieldType {
+	case FormBoolean:
+		switch strings.ToLower(cell) {
+		case "1", "true", "yes", "y", "x":
+			return "1"
+		}
+		return ""
+	case FormTimeStamp:
+		return importTimestamp.ReplaceAllString(cell, "${1}T${2}")
+	case FormSelect, FormRadio:
+		return importOption(field, cell)
+	case FormMultiSelect:
+		vals := make([]string, 0)
+		for _, v := range strings.FieldsFunc(cell, func(r rune) bool { return r == '\n' || r == ';' }) {
+			if v = strings.TrimSpace(v); v != "" {
+				vals = append(vals, importOption(field, v))
+			}
+		}
+		return strings.Join(vals, multiValueSeparator)
+	}
+	return cell
+}
+
+// importOption is the value of the option with the value or label
+func importOption(field *FormField, cell string) string {
+	if field.hasOption(cell) {
+		return cell
+	}
+	for _, opt := range field.Options {
+		if strings.EqualFold(opt.Label, cell) {
+			return opt.Value
+		}
+	}
+	return cell
+}
+
+// importCSV inserts a record for each row of the csv, created by the username. Each row is validated
+// the same as a submission of the form, then they're all inserted in one transaction, so either every
+// row is imported or none are. The timestamps in the file are in the given timezone offset, in
+// minutes as returned by javascript's getTimezoneOffset.
+func importCSV(ctx context.Context, username string, frm *Form, r io.Reader, tzOffset string) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	headers, err := reader.Read()
+	if err == io.EOF {
+		return 0, &ImportError{Problems: []string{"the file is empty"}}
+	}
+	if err != nil {
+		return 0, &ImportError{Problems: []string{err.Error()}}
+	}
+	fields, problems := importColumns(frm, headers)
+	if len(problems) > 0 {
+		return 0, &ImportError{Problems: problems}
+	}
+
+	type importRow struct {
+		line   int
+		vals   map[string]string
+		parsed map[string]interface{}
+	}
+	rows := make([]importRow, 0)
+	importErr := &ImportError{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			importErr.Rows = append(importErr.Rows, ImportRowError{Row: line, Errors: []string{err.Error()}})
+			continue
+		}
+		vals := map[string]string{"id": "", "timezone-offset": tzOffset}
+		for i, cell := range record {
+			if i < len(fields) && fields[i] != nil {
+				vals[fields[i].Name] = importValue(fields[i], cell)
+			}
+		}
+		parsed, err := validateSubmission(ctx, frm, vals, groupRows{})
+		if verr, ok := err.(*ValidationError); ok {
+			rowErr := ImportRowError{Row: line}
+			for _, field := range frm.Fields {
+				if msg, exists := verr.Fields[field.Name]; exists {
+					rowErr.Errors = append(rowErr.Errors, field.Label+": "+msg)
+				}
+			}
+			importErr.Rows = append(importErr.Rows, rowErr)
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		rows = append(rows, importRow{line: line, vals: vals, parsed: parsed})
+	}
+	if len(importErr.Rows) > 0 {
+		return 0, importErr
+	}
+
+	// ldap fields not in the file are those of the user importing it
+	ldapValues := make(map[string]string)
+	if frm.UseLDAPFields {
+		if ldapValues, err = getLDAPValues(username); err != nil {
+			return 0, errors.Wrap(err, "unable to get ldap fields from server")
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to start transaction")
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, row := range rows {
+		rowLdap := make(map[string]string)
+		for name, val := range ldapValues {
+			rowLdap[name] = val
+		}
+		for _, field := range fields {
+			if field != nil && field.IsLDAPPopulated {
+				rowLdap[field.Name] = row.vals[field.Name]
+			}
+		}
+		if _, err := saveRecord(ctx, tx, username, frm, row.vals, row.parsed, map[string]*fileChange{}, rowLdap); err != nil {
+			return 0, &ImportError{Rows: []ImportRowError{{Row: row.line, Errors: []string{err.Error()}}}}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "unable to commit")
+	}
+	return len(rows), nil
+}
+
+// ServeImport shows the import page to the form's admins, and imports the uploaded csv
+func ServeImport(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	frm, err := forms.get(ctx, mux.Vars(req)["table_name"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	username, ok := formUser(w, req, frm)
+	if !ok {
+		return
+	}
+	if isAdmin, _ := frm.Admins[username]; !isAdmin {
+		http.Error(w, "Only form admins can import records", http.StatusForbidden)
+		return
+	}
+
+	status := http.StatusOK
+	data := map[string]interface{}{"frm": frm, "username": username}
+	if req.Method == http.MethodPost {
+		req.Body = http.MaxBytesReader(w, req.Body, maxRequestSize)
+		file, _, err := req.FormFile("csv")
+		if err != nil {
+			http.Error(w, "Unable to read the file: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer func() { _ = file.Close() }()
+		imported, err := importCSV(ctx, username, frm, file, req.FormValue("timezone-offset"))
+		if ierr, ok := err.(*ImportError); ok {
+			status = http.StatusUnprocessableEntity
+			data["importError"] = ierr
+		} else if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else {
+			data["imported"] = imported
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := importTemplate.Execute(buf, data); err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = buf.WriteTo(w)
+}
+
+// runImport is the import subcommand, importing a csv file into a form from the command line:
+//
+//	sql-form import -user name form_path file.csv
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	username := flags.String("user", "import", "the user the records are created by")
+	_ = flags.Parse(args)
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: sql-form import [-user name] form_path file.csv")
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	frm, err := loadForm(ctx, flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	f, err := os.Open(flags.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// timestamps in the file are in the local timezone
+	_, offset := time.Now().Zone()
+	imported, err := importCSV(ctx, *username, frm, f, strconv.Itoa(-offset/60))
+	if ierr, ok := err.(*ImportError); ok {
+		for _, problem := range ierr.Problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		for _, row := range ierr.Rows {
+			fmt.Fprintf(os.Stderr, "row %d: %s\n", row.Row, strings.Join(row.Errors, ", "))
+		}
+		fmt.Fprintln(os.Stderr, "nothing was imported")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("imported %d records into %s\n", imported, frm.TableName)
+}
@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+// TestImportValueRoundTrip checks the values of a csv export are imported as they were saved
+func TestImportValueRoundTrip(t *testing.T) {
+	field := &FormField{Name: "notes", FieldType: FormText}
+	for _, value := range []string{
+		"", "plain", "=1+2", "+44 20 7946 0000", "-5", "-1e3", "-x", "@SUM(A1)", "\tx",
+		"'", "''", "'quoted", "'=1+2", "'-5", "it's",
+	} {
+		cell := csvCell(value)
+		if got := importValue(field, cell); got != value {
+			t.Errorf("%q exported as %q imported as %q", value, cell, got)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// listPageSize is the number of records shown on each page of the list
+const listPageSize = 50
+
+// ListQuery is the page, sort, filters and search term of the list of records, read from the request's
+// query string. Only the summary columns can be sorted or filtered, so user input never reaches the
+// query as a column name.
+type ListQuery struct {
+	Page    int
+	Sort    string
+	Desc    bool
+	Filters map[string]string
+	Search  string
+	// Total is the number of records matching the filters, set when the list is loaded
+	Total int
+	// columns are the sortable and filterable fields, keyed by name
+	columns map[string]*FormField
+	// searchColumns are the text columns searched, quoted
+	searchColumns []string
+	fullText      bool
+	// highlight matches the words of the search term
+	highlight *regexp.Regexp
+}
+
+// listColumns are the columns shown in the list that aren't fields of the form
+var listColumns = map[string]*FormField{
+	"id":           {Name: "id", FieldType: FormInteger},
+	"created_user": {Name: "created_user", FieldType: FormVarChar},
+	"created_ts":   {Name: "created_ts", FieldType: FormTimeStamp},
+}
+
+// parseListQuery reads the list's query string, ignoring any columns that aren't in the summary.
+// Filters are given as filter-<column>=value, and the search term as q.
+func parseListQuery(frm *Form, req *http.Request) *ListQuery {
+	q := newListQuery(frm)
+	params := req.URL.Query()
+	if page, err := strconv.Atoi(params.Get("page")); err == nil && page > 0 {
+		q.Page = page
+	}
+	if sort := params.Get("sort"); q.CanSort(sort) {
+		q.Sort = sort
+		q.Desc = params.Get("desc") != ""
+	}
+	for name := range q.columns {
+		if val := strings.TrimSpace(params.Get("filter-" + name)); val != "" {
+			q.Filters[name] = val
+		}
+	}
+	q.Search = strings.TrimSpace(params.Get("q"))
+	if words := strings.Fields(q.Search); len(words) > 0 {
+		for i, w := range words {
+			words[i] = regexp.QuoteMeta(w)
+		}
+		q.highlight = regexp.MustCompile("(?i)" + strings.Join(words, "|"))
+	}
+	return q
+}
+
+// newListQuery is the first page of the list, newest first, with the form's summary columns
+func newListQuery(frm *Form) *ListQuery {
+	q := &ListQuery{Page: 1, Sort: "created_ts", Desc: true, Filters: make(map[string]string)}
+	q.columns = make(map[string]*FormField)
+	for name, field := range listColumns {
+		q.columns[name] = field
+	}
+	for _, field := range frm.Fields {
+		if field.IncludeInSummary && (field.isColumn() || field.junction != nil) {
+			q.columns[field.Name] = field
+		}
+		if field.isColumn() && (field.FieldType == FormText || field.FieldType == FormVarChar) {
+			q.searchColumns = append(q.searchColumns, dialect.QuoteIdent(field.Name))
+		}
+	}
+	q.fullText = frm.fullText
+	return q
+}
+
+// CanSort reports whether the list can be sorted by the column, fields in a junction table can
+// only be filtered
+func (q *ListQuery) CanSort(name string) bool {
+	field, exists := q.columns[name]
+	return exists && field.junction == nil
+}
+
+// Filter is the value the column is filtered by
+func (q *ListQuery) Filter(name string) string {
+	return q.Filters[name]
+}
+
+// Filtered reports whether any filters or a search are applied
+func (q *ListQuery) Filtered() bool {
+	return len(q.Filters) > 0 || q.Search != ""
+}
+
+// Highlight marks the words of the search term in the value
+func (q *ListQuery) Highlight(value string) template.HTML {
+	if q.highlight == nil {
+		return template.HTML(template.HTMLEscapeString(value))
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range q.highlight.FindAllStringIndex(value, -1) {
+		b.WriteString(template.HTMLEscapeString(value[last:m[0]]))
+		b.WriteString("<mark>" + template.HTMLEscapeString(value[m[0]:m[1]]) + "</mark>")
+		last = m[1]
+	}
+	b.WriteString(template.HTMLEscapeString(value[last:]))
+	return template.HTML(b.String())
+}
+
+// params are the sort, filters and search as a query string, without the page
+func (q *ListQuery) params(sort string, desc bool) url.Values {
+	params := url.Values{}
+	params.Set("sort", sort)
+	if desc {
+		params.Set("desc", "1")
+	}
+	for name, val := range q.Filters {
+		params.Set("filter-"+name, val)
+	}
+	if q.Search != "" {
+		params.Set("q", q.Search)
+	}
+	return params
+}
+
+// SortURL is the query string to sort by the column, reversing the order if it's already sorted by it
+func (q *ListQuery) SortURL(name string) string {
+	return "?" + q.params(name, name == q.Sort && !q.Desc).Encode()
+}
+
+// SortIndicator shows which way the list is sorted, if it's sorted by the column
+func (q *ListQuery) SortIndicator(name string) string {
+	if name != q.Sort {
+		return ""
+	}
+	if q.Desc {
+		return "▼"
+	}
+	return "▲"
+}
+
+// Pages is the number of pages of records
+func (q *ListQuery) Pages() int {
+	if q.Total == 0 {
+		return 1
+	}
+	return (q.Total + listPageSize - 1) / listPageSize
+}
+
+// QueryString is the sort, filters and search as a query string, e.g. for exporting what's listed
+func (q *ListQuery) QueryString() string {
+	return "?" + q.params(q.Sort, q.Desc).Encode()
+}
+
+// PageURL is the query string for a page of the list
+func (q *ListQuery) PageURL(page int) string {
+	params := q.params(q.Sort, q.Desc)
+	params.Set("page", strconv.Itoa(page))
+	return "?" + params.Encode()
+}
+
+// PrevPage is the previous page, or 0 on the first
+func (q *ListQuery) PrevPage() int {
+	return q.Page - 1
+}
+
+// NextPage is the next page, or 0 on the last
+func (q *ListQuery) NextPage() int {
+	if q.Page >= q.Pages() {
+		return 0
+	}
+	return q.Page + 1
+}
+
+// orderBy is the ORDER BY clause of the list, with the id to keep the paging stable
+func (q *ListQuery) orderBy() string {
+	dir := " ASC"
+	if q.Desc {
+		dir = " DESC"
+	}
+	return " ORDER BY " + dialect.QuoteIdent(q.Sort) + dir + ", id" + dir
+}
+
+// where adds the filters and search to the conditions of the list query, numbering the placeholders
+// after the existing args
+func (q *ListQuery) where(conds []string, args []interface{}) ([]string, []interface{}) {
+	for name, val := range q.Filters {
+		field := q.columns[name]
+		col := dialect.QuoteIdent(name)
+		p := dialect.Placeholder(len(args) + 1)
+		switch {
+		case field.junction != nil:
+			jt := field.junction
+			conds = append(conds, fmt.Sprintf(
+				"id IN (SELECT %s FROM %s WHERE %s = %s)",
+				dialect.QuoteIdent(jt.parentColumn),
+				quoteTable(jt.table),
+				dialect.CastText(dialect.QuoteIdent(jt.valueColumn)),
+				p))
+			args = append(args, val)
+		case field.FieldType == FormBoolean:
+			conds = append(conds, col+" = "+p)
+			args = append(args, val == "true")
+		case field.FieldType == FormSelect || field.FieldType == FormRadio:
+			conds = append(conds, dialect.CastText(col)+" = "+p)
+			args = append(args, val)
+		default:
+			conds = append(conds, "LOWER("+dialect.CastText(col)+") LIKE "+p+dialect.LikeEscape())
+			args = append(args, containsPattern(strings.ToLower(val)))
+		}
+	}
+	if q.Search != "" {
+		if len(q.searchColumns) == 0 {
+			// nothing can match
+			return append(conds, "1 = 0"), args
+		}
+		cond, searchArgs := dialect.TextSearch(q.searchColumns, q.Search, len(args)+1, q.fullText)
+		conds = append(conds, cond)
+		args = append(args, searchArgs...)
+	}
+	return conds, args
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// listTestForm has a summary column of each kind that's filtered differently
+func listTestForm() *Form {
+	return &Form{Fields: []*FormField{
+		{Name: "name", FieldType: FormVarChar, IncludeInSummary: true},
+		{Name: "notes", FieldType: FormText},
+		{Name: "active", FieldType: FormBoolean, IncludeInSummary: true},
+		{Name: "colour", FieldType: FormSelect, IncludeInSummary: true},
+		{Name: "tags", FieldType: FormMultiSelect, IncludeInSummary: true,
+			junction: &junctionTable{table: "test_form_tags", parentColumn: "test_form_id", valueColumn: "tag"}},
+		{Name: "secret", FieldType: FormVarChar},
+	}}
+}
+
+var placeholderNumber = regexp.MustCompile(`\?(\d+)`)
+
+// resolveConds replaces the placeholders in the conditions with their args, checking each arg is
+// used exactly once, and sorts them as the filters are in no particular order
+func resolveConds(t *testing.T, conds []string, args []interface{}) []string {
+	used := make(map[int]bool)
+	out := make([]string, 0, len(conds))
+	for _, cond := range conds {
+		out = append(out, placeholderNumber.ReplaceAllStringFunc(cond, func(p string) string {
+			n, _ := strconv.Atoi(p[1:])
+			if n < 1 || n > len(args) {
+				t.Fatalf("%s in %q has no arg", p, cond)
+			}
+			if used[n] {
+				t.Fatalf("%s is used twice", p)
+			}
+			used[n] = true
+			return fmt.Sprintf("%#v", args[n-1])
+		}))
+	}
+	if len(used) != len(args) {
+		t.Fatalf("%d of the %d args are used", len(used), len(args))
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestListQueryWhere(t *testing.T) {
+	dialect = sqliteDialect{}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"nothing", "", []string{`deleted_ts IS NULL`, `created_user = "bob"`}},
+		{"filters and search", "?filter-name=Ann&filter-active=true&filter-colour=Red&filter-tags=x&q=hello", []string{
+			`("name" LIKE "%hello%" ESCAPE '\' OR "notes" LIKE "%hello%" ESCAPE '\' OR "secret" LIKE "%hello%" ESCAPE '\')`,
+			`CAST("colour" AS TEXT) = "Red"`,
+			`LOWER(CAST("name" AS TEXT)) LIKE "%ann%" ESCAPE '\'`,
+			`"active" = true`,
+			`created_user = "bob"`,
+			`deleted_ts IS NULL`,
+			`id IN (SELECT "test_form_id" FROM "test_form_tags" WHERE CAST("tag" AS TEXT) = "x")`,
+		}},
+		{"columns not in the summary can't be filtered", "?filter-secret=x&filter-notes=y", []string{
+			`created_user = "bob"`,
+			`deleted_ts IS NULL`,
+		}},
+		{"list columns", "?filter-created_user=al", []string{
+			`LOWER(CAST("created_user" AS TEXT)) LIKE "%al%" ESCAPE '\'`,
+			`created_user = "bob"`,
+			`deleted_ts IS NULL`,
+		}},
+		{"wildcards are matched literally", "?filter-name=50%25_off%5C", []string{
+			`LOWER(CAST("name" AS TEXT)) LIKE "%50\\%\\_off\\\\%" ESCAPE '\'`,
+			`created_user = "bob"`,
+			`deleted_ts IS NULL`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := parseListQuery(listTestForm(), httptest.NewRequest("GET", "/test_form/list"+tt.query, nil))
+			conds, args := q.where([]string{"deleted_ts IS NULL", "created_user = ?1"}, []interface{}{"bob"})
+			got := resolveConds(t, conds, args)
+			want := append([]string{}, tt.want...)
+			sort.Strings(want)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestListQuerySearchWithoutColumns(t *testing.T) {
+	dialect = sqliteDialect{}
+
+	frm := &Form{Fields: []*FormField{{Name: "age", FieldType: FormInteger, IncludeInSummary: true}}}
+	q := parseListQuery(frm, httptest.NewRequest("GET", "/test_form/list?q=hello", nil))
+	conds, args := q.where(nil, nil)
+	if len(conds) != 1 || conds[0] != "1 = 0" || len(args) != 0 {
+		t.Errorf("got %q %v, want nothing to match", conds, args)
+	}
+}
+
+func TestListQueryOrderBy(t *testing.T) {
+	dialect = sqliteDialect{}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", ` ORDER BY "created_ts" DESC, id DESC`},
+		{"?sort=name", ` ORDER BY "name" ASC, id ASC`},
+		{"?sort=name&desc=1", ` ORDER BY "name" DESC, id DESC`},
+		{"?sort=id", ` ORDER BY "id" ASC, id ASC`},
+		// only the summary columns can be sorted by
+		{"?sort=secret", ` ORDER BY "created_ts" DESC, id DESC`},
+		{"?sort=name%22%3B+DROP+TABLE+x", ` ORDER BY "created_ts" DESC, id DESC`},
+		// junction tables have no column to sort by
+		{"?sort=tags", ` ORDER BY "created_ts" DESC, id DESC`},
+	}
+	for _, tt := range tests {
+		q := parseListQuery(listTestForm(), httptest.NewRequest("GET", "/test_form/list"+tt.query, nil))
+		if got := q.orderBy(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
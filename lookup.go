@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxLookupOptions is the most rows a referenced table can have to be shown as a select,
+// the user searches larger tables as they type
+const maxLookupOptions = 200
+
+// maxLookupResults is the number of matches returned when searching a lookup
+const maxLookupResults = 20
+
+// lookupSource is the table a foreign key field's values come from
+type lookupSource struct {
+	table       string
+	valueColumn string
+	labelColumn string
+}
+
+// applyForeignKey makes the field a select of the rows in the referenced table, or a lookup
+// searched as the user types if there are too many rows for a select
+func applyForeignKey(ctx context.Context, field *FormField, fk *foreignKey) error {
+	src := &lookupSource{
+		table:       fk.refTable,
+		valueColumn: fk.refColumn,
+		labelColumn: fk.refColumn,
+	}
+	if field.displayColumn != "" {
+		src.labelColumn = field.displayColumn
+	}
+	field.lookup = src
+
+	count := 0
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+quoteTable(src.table)).Scan(&count)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to count the rows of %s", src.table))
+	}
+	if count > maxLookupOptions {
+		field.FieldType = FormLookup
+		return nil
+	}
+
+	query := fmt.Sprintf(
+		"SELECT %s, %s FROM %s ORDER BY %s",
+		dialect.QuoteIdent(src.valueColumn),
+		dialect.QuoteIdent(src.labelColumn),
+		quoteTable(src.table),
+		dialect.QuoteIdent(src.labelColumn))
+	field.Options, err = queryOptions(ctx, query)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to read the options from %s", src.table))
+	}
+	if field.optionsAsRadio {
+		field.FieldType = FormRadio
+	} else {
+		field.FieldType = FormSelect
+	}
+	return nil
+}
+
+// searchLookup finds the rows of the referenced table where the label or value contains the search term
+func searchLookup(ctx context.Context, src *lookupSource, term string) ([]FieldOption, error) {
+	value := dialect.QuoteIdent(src.valueColumn)
+	label := dialect.QuoteIdent(src.labelColumn)
+	query := fmt.Sprintf(
+		"SELECT %s, %s FROM %s WHERE LOWER(%s) LIKE %s%s OR LOWER(%s) LIKE %s%s ORDER BY %s",
+		value,
+		label,
+		quoteTable(src.table),
+		dialect.CastText(label),
+		dialect.Placeholder(1),
+		dialect.LikeEscape(),
+		dialect.CastText(value),
+		dialect.Placeholder(2),
+		dialect.LikeEscape(),
+		label)
+	query = dialect.Paginate(query, maxLookupResults, 0)
+	pattern := containsPattern(strings.ToLower(term))
+	return queryOptions(ctx, query, pattern, pattern)
+}
+
+// findLookupOption returns the row of the referenced table with the value, or nil if there isn't one
+func findLookupOption(ctx context.Context, src *lookupSource, value string) (*FieldOption, error) {
+	query := fmt.Sprintf(
+		"SELECT %s, %s FROM %s WHERE %s = %s",
+		dialect.QuoteIdent(src.valueColumn),
+		dialect.QuoteIdent(src.labelColumn),
+		quoteTable(src.table),
+		dialect.CastText(dialect.QuoteIdent(src.valueColumn)),
+		dialect.Placeholder(1))
+	var optValue, optLabel sql.NullString
+	err := db.QueryRowContext(ctx, query, value).Scan(&optValue, &optLabel)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to find %s in %s", value, src.table))
+	}
+	return &FieldOption{Value: optValue.String, Label: optLabel.String}, nil
+}
+
+// ServeLookup searches the table referenced by a lookup field, returning the matching options as json.
+// Use ?q= to search, or ?value= to get the option for a value.
+func ServeLookup(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	ctx := req.Context()
+
+	frm, err := forms.get(ctx, vars["table_name"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := formUser(w, req, frm); !ok {
+		return
+	}
+
+	var field *FormField
+	for _, f := range frm.Fields {
+		if f.Name == vars["field"] && f.lookup != nil {
+			field = f
+		}
+	}
+	if field == nil {
+		http.Error(w, "No lookup field "+vars["field"], http.StatusNotFound)
+		return
+	}
+
+	options := make([]FieldOption, 0)
+	if value := req.FormValue("value"); value != "" {
+		var opt *FieldOption
+		opt, err = findLookupOption(ctx, field.lookup, value)
+		if opt != nil {
+			options = append(options, *opt)
+		}
+	} else {
+		options, err = searchLookup(ctx, field.lookup, req.FormValue("q"))
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(options); err != nil {
+		log.Println(err)
+	}
+}
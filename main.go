@@ -1,6 +1,9 @@
 package main
 
-import "log"
+import (
+	"log"
+	"os"
+)
 
 func main() {
 	conf := parseConfig("config.toml")
@@ -17,5 +20,10 @@ func main() {
 			log.Fatal(err)
 		}
 	}()
+
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImport(os.Args[2:])
+		return
+	}
 	serve(conf)
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+)
+
+// multiValueSeparator joins the selected values of a multi-select in the form values, it can't
+// appear in a value submitted from a checkbox
+const multiValueSeparator = "\n"
+
+// multiSelectDelimiter joins the selected values stored in a plain text column
+const multiSelectDelimiter = ";"
+
+// multiSelectStorage is how the selected values of a multi-select field are saved
+type multiSelectStorage int
+
+const (
+	// a text column holding the values joined with multiSelectDelimiter
+	delimitedStorage multiSelectStorage = iota
+	// a postgres array column, e.g. text[]
+	arrayStorage
+	// a row per value in a child table, the field has no column in the form's table
+	junctionStorage
+)
+
+// junctionTable is a child table holding the selected values of a multi-select, one row per value
+type junctionTable struct {
+	table        string
+	parentColumn string
+	valueColumn  string
+}
+
+var junctionTableSpec = regexp.MustCompile(`^\s*([\w.]+)\s*\(\s*(\w+)\s*,\s*(\w+)\s*\)\s*$`)
+
+// parseJunctionTable reads a junction_table label, in the format table(parent_id_column, value_column)
+func parseJunctionTable(spec string) (*junctionTable, error) {
+	m := junctionTableSpec.FindStringSubmatch(spec)
+	if m == nil {
+		return nil, errors.Errorf("junction table %q is not in the format table(parent_id_column, value_column)", spec)
+	}
+	return &junctionTable{table: m[1], parentColumn: m[2], valueColumn: m[3]}, nil
+}
+
+// isColumn is false for fields that aren't stored in a column of the form's table
+func (f *FormField) isColumn() bool {
+	return f.junction == nil && f.Group == nil && f.attachments == nil
+}
+
+// splitMultiValue splits the selected values of a multi-select out of the form value
+func splitMultiValue(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, multiValueSeparator)
+}
+
+// IsSelected reports whether the option is one of the selected values of a multi-select
+func (f *FormField) IsSelected(value string, option string) bool {
+	for _, v := range splitMultiValue(value) {
+		if v == option {
+			return true
+		}
+	}
+	return false
+}
+
+// multiValuesFromInterface reads the values of a multi-select column, either a postgres array
+// or delimited text
+func multiValuesFromInterface(val interface{}) []string {
+	str := stringFromInterface(val)
+	if strings.HasPrefix(str, "{") && strings.HasSuffix(str, "}") {
+		var arr pq.StringArray
+		if err := arr.Scan(str); err == nil {
+			return arr
+		}
+	}
+	out := make([]string, 0)
+	for _, v := range strings.Split(str, multiSelectDelimiter) {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// multiSelectColumnValue converts the selected values to the value saved in the field's column
+func multiSelectColumnValue(field *FormField, vals []string) interface{} {
+	if vals == nil {
+		return nil
+	}
+	if field.multiStorage == arrayStorage {
+		return pq.Array(vals)
+	}
+	return strings.Join(vals, multiSelectDelimiter)
+}
+
+// loadJunctionValues reads the selected values of a junction table multi-select for each of the
+// given record ids, keyed by id
+func loadJunctionValues(ctx context.Context, q queryer, field *FormField, ids []string) (map[string][]string, error) {
+	out := make(map[string][]string)
+	if len(ids) == 0 {
+		return out, nil
+	}
+	jt := field.junction
+	query := fmt.Sprintf(
+		"SELECT %s, %s FROM %s WHERE %s IN (%s) ORDER BY %s",
+		dialect.CastText(dialect.QuoteIdent(jt.parentColumn)),
+		dialect.CastText(dialect.QuoteIdent(jt.valueColumn)),
+		quoteTable(jt.table),
+		dialect.QuoteIdent(jt.parentColumn),
+		strings.Join(placeholders(1, len(ids)), ", "),
+		dialect.QuoteIdent(jt.valueColumn))
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("unable to query the junction table %s", jt.table))
+	}
+	for rows.Next() {
+		var id, val string
+		if err := rows.Scan(&id, &val); err != nil {
+			_ = rows.Close()
+			return nil, errors.Wrap(err, fmt.Sprintf("unable to read the junction table %s", jt.table))
+		}
+		out[id] = append(out[id], val)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, errors.Wrap(err, "unable to close junction table rows")
+	}
+	return out, nil
+}
+
+// addJunctionValues loads the values of any junction table multi-selects into the rows
+func addJunctionValues(ctx context.Context, q queryer, frm *Form, rows []map[string]string, summaryOnly bool) error {
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row["id"])
+	}
+	for _, field := range frm.Fields {
+		if field.junction == nil || (summaryOnly && !field.IncludeInSummary) {
+			continue
+		}
+		vals, err := loadJunctionValues(ctx, q, field, ids)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			row[field.Name] = strings.Join(vals[row["id"]], multiValueSeparator)
+		}
+	}
+	return nil
+}
+
+// saveJunctionValues replaces the selected values of a junction table multi-select for the record
+func saveJunctionValues(ctx context.Context, q queryer, field *FormField, id int, vals []string) error {
+	jt := field.junction
+	query := fmt.Sprintf(
+		"DELETE FROM %s WHERE %s = %s",
+		quoteTable(jt.table),
+		dialect.QuoteIdent(jt.parentColumn),
+		dialect.Placeholder(1))
+	if _, err := q.ExecContext(ctx, query, id); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("unable to clear the junction table %s", jt.table))
+	}
+	query = fmt.Sprintf(
+		"INSERT INTO %s (%s, %s) VALUES (%s, %s)",
+		quoteTable(jt.table),
+		dialect.QuoteIdent(jt.parentColumn),
+		dialect.QuoteIdent(jt.valueColumn),
+		dialect.Placeholder(1),
+		dialect.Placeholder(2))
+	for _, val := range vals {
+		if _, err := q.ExecContext(ctx, query, id, val); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("unable to save to the junction table %s", jt.table))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/jcmturner/goidentity/v6"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenID Connect login, for users who can't use kerberos. Users are sent to the identity provider to
+// log in (the authorization code flow), and their username from the id token is kept in the same
+// session cookie, and the same goidentity credentials, as the spnego login.
+
+// the keys of the session values
+const (
+	oidcCredentialsKey = "sql-form/oidcCredentials"
+	oidcStateKey       = "sql-form/oidcState"
+)
+
+// oidcSessionLength is how long a login lasts before the user is sent to the identity provider again
+const oidcSessionLength = 8 * time.Hour
+
+// oidcLoginTimeout is how long the user has to log in at the identity provider
+const oidcLoginTimeout = 10 * time.Minute
+
+// logoutURL logs the user out, it's set when they can
+var logoutURL string
+
+// oidcState is kept in the session while the user logs in at the identity provider
+type oidcState struct {
+	State    string
+	Nonce    string
+	Verifier string
+	Next     string
+	Started  time.Time
+}
+
+// oidcProvider is the identity provider as read from its discovery document
+type oidcProvider struct {
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	// endSession logs the user out at the identity provider, if it can
+	endSession string
+}
+
+type oidcMiddleware struct {
+	conf   authConfig
+	sm     SessionMgr
+	client *http.Client
+
+	// the provider is read when it's first needed, so the service can start while the identity
+	// provider is down
+	mu       sync.Mutex
+	provider *oidcProvider
+}
+
+func newOIDCMiddleware(conf authConfig, secure bool) (*oidcMiddleware, error) {
+	if conf.OIDCClientID == "" || conf.OIDCRedirectURL == "" {
+		return nil, errors.New("oidcClientID and oidcRedirectURL must be set to log in with oidcIssuer")
+	}
+	if conf.SessionKey == "" {
+		return nil, errors.New("sessionKey must be set to log in with oidcIssuer, the session cookies are signed with it")
+	}
+	if conf.OIDCScopes == nil {
+		conf.OIDCScopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	if conf.OIDCUsernameClaim == "" {
+		conf.OIDCUsernameClaim = "preferred_username"
+	}
+	return &oidcMiddleware{
+		conf:   conf,
+		sm:     NewSessionMgr(conf.SessionKey, conf.CookieName, secure),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Middleware adds the logged in user's credentials to the request. Pages send users who aren't logged
+// in to the identity provider, other requests are refused.
+func (o *oidcMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/auth/") || strings.HasPrefix(r.URL.Path, "/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if creds, err := o.sessionCredentials(r); err == nil {
+			next.ServeHTTP(w, goidentity.AddToHTTPRequestContext(creds, r))
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			apiError(w, http.StatusUnauthorized, "not logged in", nil)
+			return
+		}
+		if r.Method != http.MethodGet {
+			// whatever was submitted would be lost by logging in
+			http.Error(w, "Your login has expired, reload the page to log in again", http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, "/auth/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+	})
+}
+
+// sessionCredentials are the credentials of the logged in user, an error if they aren't logged in
+func (o *oidcMiddleware) sessionCredentials(r *http.Request) (*credentials.Credentials, error) {
+	b, err := o.sm.Get(r, oidcCredentialsKey)
+	if err != nil {
+		return nil, err
+	}
+	creds := &credentials.Credentials{}
+	if err := creds.Unmarshal(b); err != nil {
+		return nil, errors.Wrap(err, "credentials malformed in session")
+	}
+	if !creds.Authenticated() || creds.Expired() {
+		return nil, errors.New("login has expired")
+	}
+	return creds, nil
+}
+
+// ServeLogin sends the user to the identity provider to log in, to come back to the next page
+func (o *oidcMiddleware) ServeLogin(w http.ResponseWriter, req *http.Request) {
+	provider, err := o.getProvider()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Unable to reach the identity provider", http.StatusBadGateway)
+		return
+	}
+
+	state := oidcState{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Verifier: oauth2.GenerateVerifier(),
+		Next:     req.URL.Query().Get("next"),
+		Started:  time.Now(),
+	}
+	b, err := json.Marshal(state)
+	if err == nil {
+		err = o.sm.New(w, req, oidcStateKey, b)
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the code can only be redeemed with the verifier (PKCE), in case it's intercepted
+	http.Redirect(w, req, provider.oauth2.AuthCodeURL(
+		state.State, oidc.Nonce(state.Nonce), oauth2.S256ChallengeOption(state.Verifier)), http.StatusFound)
+}
+
+// ServeCallback is where the identity provider sends the user back to after they've logged in. The
+// code is exchanged for their id token, and they're logged in with its username.
+func (o *oidcMiddleware) ServeCallback(w http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	if msg := params.Get("error"); msg != "" {
+		if desc := params.Get("error_description"); desc != "" {
+			msg += ": " + desc
+		}
+		http.Error(w, "Unable to log in: "+msg, http.StatusUnauthorized)
+		return
+	}
+
+	var state oidcState
+	b, err := o.sm.Get(req, oidcStateKey)
+	if err == nil {
+		err = json.Unmarshal(b, &state)
+	}
+	if err != nil || state.State == "" || state.State != params.Get("state") ||
+		time.Since(state.Started) > oidcLoginTimeout {
+		http.Error(w, "The login has expired or was started in another browser, please try again", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := o.exchangeCode(req.Context(), params.Get("code"), state)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Unable to log in: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	username, _ := claims[o.conf.OIDCUsernameClaim].(string)
+	if username == "" {
+		log.Printf("oidc: the id token has no %s claim", o.conf.OIDCUsernameClaim)
+		http.Error(w, "Unable to log in: the identity provider didn't give a username", http.StatusUnauthorized)
+		return
+	}
+
+	creds := credentials.New(username, "")
+	if name, _ := claims["name"].(string); name != "" {
+		creds.SetDisplayName(name)
+	}
+	if email, _ := claims["email"].(string); email != "" {
+		creds.SetAttribute("email", email)
+	}
+	creds.SetAuthTime(time.Now())
+	creds.SetValidUntil(time.Now().Add(oidcSessionLength))
+	creds.SetAuthenticated(true)
+	b, err = creds.Marshal()
+	if err == nil {
+		err = o.sm.New(w, req, oidcCredentialsKey, b)
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// only go back to pages of this site
+	next := state.Next
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = "/"
+	}
+	http.Redirect(w, req, next, http.StatusFound)
+}
+
+// ServeLogout ends the user's session, and their session with the identity provider if it can
+func (o *oidcMiddleware) ServeLogout(w http.ResponseWriter, req *http.Request) {
+	if err := o.sm.Clear(w, req); err != nil {
+		log.Println(err)
+	}
+	if provider, err := o.getProvider(); err == nil && provider.endSession != "" {
+		params := url.Values{}
+		params.Set("client_id", o.conf.OIDCClientID)
+		sep := "?"
+		if strings.Contains(provider.endSession, "?") {
+			sep = "&"
+		}
+		http.Redirect(w, req, provider.endSession+sep+params.Encode(), http.StatusFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprintln(w, "You have logged out.")
+}
+
+// exchangeCode redeems the code for the user's id token at the token endpoint, returning its claims
+// once it's verified
+func (o *oidcMiddleware) exchangeCode(ctx context.Context, code string, state oidcState) (map[string]interface{}, error) {
+	provider, err := o.getProvider()
+	if err != nil {
+		return nil, err
+	}
+	ctx = oidc.ClientContext(ctx, o.client)
+	token, err := provider.oauth2.Exchange(ctx, code, oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		return nil, errors.Wrap(err, "the identity provider refused the code")
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, errors.New("the identity provider didn't return an id token")
+	}
+	idToken, err := provider.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != state.Nonce {
+		return nil, errors.New("the id token is for a different login")
+	}
+	claims := make(map[string]interface{})
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, errors.Wrap(err, "the id token is malformed")
+	}
+	// the authorized party must be us when the token is for others too
+	azp, _ := claims["azp"].(string)
+	if azp != "" && azp != o.conf.OIDCClientID {
+		return nil, errors.Errorf("the id token was issued to %s, not this client", azp)
+	}
+	if azp == "" && len(idToken.Audience) > 1 {
+		return nil, errors.New("the id token is for several clients and doesn't say which it was issued to")
+	}
+	return claims, nil
+}
+
+// getProvider reads the identity provider's discovery document, the first time it's needed
+func (o *oidcMiddleware) getProvider() (*oidcProvider, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.provider != nil {
+		return o.provider, nil
+	}
+	// the provider keeps the client to read its keys with
+	provider, err := oidc.NewProvider(oidc.ClientContext(context.Background(), o.client), o.conf.OIDCIssuer)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the identity provider's configuration")
+	}
+	endpoint := provider.Endpoint()
+	// public clients have no secret to send in the basic auth header
+	endpoint.AuthStyle = oauth2.AuthStyleInHeader
+	if o.conf.OIDCClientSecret == "" {
+		endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
+	var discovery struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&discovery); err != nil {
+		return nil, errors.Wrap(err, "unable to read the identity provider's configuration")
+	}
+	o.provider = &oidcProvider{
+		oauth2: oauth2.Config{
+			ClientID:     o.conf.OIDCClientID,
+			ClientSecret: o.conf.OIDCClientSecret,
+			Endpoint:     endpoint,
+			RedirectURL:  o.conf.OIDCRedirectURL,
+			Scopes:       o.conf.OIDCScopes,
+		},
+		verifier:   provider.Verifier(&oidc.Config{ClientID: o.conf.OIDCClientID}),
+		endSession: discovery.EndSessionEndpoint,
+	}
+	return o.provider, nil
+}
+
+// randomToken is an unguessable value for the login's state and nonce
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/jcmturner/goidentity/v6"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIdP is an identity provider serving the discovery document, its keys and a token endpoint that
+// returns idToken for the code "the-code". Its server must be closed.
+type testIdP struct {
+	server  *httptest.Server
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	idToken string
+	// challenge is the PKCE code challenge of the login, checked against the verifier
+	challenge string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{rsaKey: rsaKey, ecKey: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"end_session_endpoint":                  idp.server.URL + "/logout",
+			"id_token_signing_alg_values_supported": []string{"RS256", "ES256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{KeyID: "rsa", Use: "sig", Algorithm: "RS256", Key: &rsaKey.PublicKey},
+			{KeyID: "ec", Use: "sig", Algorithm: "ES256", Key: &ecKey.PublicKey},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		id, secret, _ := r.BasicAuth()
+		verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		switch {
+		case id != "sql-form" || secret != "s3cret":
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		case r.PostFormValue("code") != "the-code" ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != idp.challenge:
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		default:
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"access_token": "the-access-token", "id_token": idp.idToken, "token_type": "Bearer"})
+		}
+	})
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *testIdP) middleware(t *testing.T) *oidcMiddleware {
+	o, err := newOIDCMiddleware(authConfig{
+		CookieName:       "sql-form-test",
+		SessionKey:       "test",
+		OIDCIssuer:       idp.server.URL,
+		OIDCClientID:     "sql-form",
+		OIDCClientSecret: "s3cret",
+		OIDCRedirectURL:  "https://forms.example.com/auth/callback",
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// claims are the claims of a valid id token for the login
+func (idp *testIdP) claims(nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                idp.server.URL,
+		"aud":                []interface{}{"sql-form", "other"},
+		"azp":                "sql-form",
+		"sub":                "1234",
+		"nonce":              nonce,
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(5 * time.Minute).Unix(),
+		"preferred_username": "ann",
+		"name":               "Ann Example",
+		"email":              "ann@example.com",
+	}
+}
+
+// sign makes a token of the claims signed with alg, with the provider's key of that type
+func (idp *testIdP) sign(t *testing.T, alg string, kid string, claims map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := encode(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, idp.rsaKey, crypto.SHA256, digest[:])
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, idp.ecKey, digest[:])
+		if err == nil {
+			// r and s are padded to the size of the curve
+			sig = make([]byte, 64)
+			rb, sb := r.Bytes(), s.Bytes()
+			copy(sig[32-len(rb):32], rb)
+			copy(sig[64-len(sb):], sb)
+		}
+	case "HS256":
+		// signed with the public key as the secret, which would pass if it were trusted
+		pub, _ := x509.MarshalPKIXPublicKey(&idp.rsaKey.PublicKey)
+		mac := hmac.New(sha256.New, pub)
+		_, _ = mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// login starts a login for the next page, returning the session cookies and the parameters sent
+// to the identity provider
+func (idp *testIdP) login(t *testing.T, o *oidcMiddleware, next string) ([]*http.Cookie, url.Values) {
+	w := httptest.NewRecorder()
+	o.ServeLogin(w, httptest.NewRequest("GET", "/auth/login?next="+url.QueryEscape(next), nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login returned %d: %s", w.Code, w.Body)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(location.String(), idp.server.URL+"/authorize?") {
+		t.Fatalf("login went to %s", location)
+	}
+	params := location.Query()
+	idp.challenge = params.Get("code_challenge")
+	return w.Result().Cookies(), params
+}
+
+// callback returns the user to the site from the identity provider
+func callback(o *oidcMiddleware, cookies []*http.Cookie, params url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/auth/callback?"+params.Encode(), nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	o.ServeCallback(w, req)
+	return w
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	tests := []struct {
+		alg, kid string
+		// the token is only for us, so needn't name the authorized party
+		onlyUs bool
+	}{{"RS256", "rsa", false}, {"ES256", "ec", false}, {"ES256", "", false}, {"RS256", "rsa", true}}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s %v", tt.alg, tt.kid, tt.onlyUs), func(t *testing.T) {
+			o := idp.middleware(t)
+			cookies, params := idp.login(t, o, "/test_form/list?page=2")
+			if params.Get("client_id") != "sql-form" || params.Get("code_challenge_method") != "S256" {
+				t.Errorf("unexpected authorization request %v", params)
+			}
+			claims := idp.claims(params.Get("nonce"))
+			if tt.onlyUs {
+				claims["aud"] = "sql-form"
+				delete(claims, "azp")
+			}
+			idp.idToken = idp.sign(t, tt.alg, tt.kid, claims)
+			w := callback(o, cookies, url.Values{"code": {"the-code"}, "state": {params.Get("state")}})
+			if w.Code != http.StatusFound || w.Header().Get("Location") != "/test_form/list?page=2" {
+				t.Fatalf("callback returned %d to %q: %s", w.Code, w.Header().Get("Location"), w.Body)
+			}
+
+			var id goidentity.Identity
+			handler := o.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				id = goidentity.FromHTTPRequestContext(r)
+			}))
+			req := httptest.NewRequest("GET", "/test_form", nil)
+			for _, c := range w.Result().Cookies() {
+				req.AddCookie(c)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if id == nil {
+				t.Fatal("no credentials in the request")
+			}
+			if id.UserName() != "ann" || id.DisplayName() != "Ann Example" || !id.Authenticated() {
+				t.Errorf("got %s %q authenticated %v", id.UserName(), id.DisplayName(), id.Authenticated())
+			}
+		})
+	}
+}
+
+func TestOIDCMiddlewareNotLoggedIn(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	o := idp.middleware(t)
+	handler := o.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the request shouldn't be served")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/test_form?x=1", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/auth/login?next=%2Ftest_form%3Fx%3D1" {
+		t.Errorf("got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("api got %d", w.Code)
+	}
+}
+
+func TestOIDCRejectedTokens(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token func(t *testing.T, nonce string) string
+		want  string
+	}{
+		{"bad signature", func(t *testing.T, nonce string) string {
+			token := idp.sign(t, "RS256", "rsa", idp.claims(nonce))
+			// the same token signed by another key
+			realKey := idp.rsaKey
+			idp.rsaKey = otherKey
+			forged := idp.sign(t, "RS256", "rsa", idp.claims(nonce))
+			idp.rsaKey = realKey
+			return token[:strings.LastIndex(token, ".")] + forged[strings.LastIndex(forged, "."):]
+		}, "failed to verify id token signature"},
+		{"tampered claims", func(t *testing.T, nonce string) string {
+			token := idp.sign(t, "ES256", "ec", idp.claims(nonce))
+			claims := idp.claims(nonce)
+			claims["preferred_username"] = "admin"
+			b, _ := json.Marshal(claims)
+			parts := strings.Split(token, ".")
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(b) + "." + parts[2]
+		}, "failed to verify id token signature"},
+		{"alg none", func(t *testing.T, nonce string) string {
+			token := idp.sign(t, "none", "rsa", idp.claims(nonce))
+			return token[:strings.LastIndex(token, ".")+1]
+		}, `unexpected signature algorithm "none"`},
+		{"alg HS256", func(t *testing.T, nonce string) string {
+			return idp.sign(t, "HS256", "rsa", idp.claims(nonce))
+		}, `unexpected signature algorithm "HS256"`},
+		{"unknown key", func(t *testing.T, nonce string) string {
+			return idp.sign(t, "RS256", "old", idp.claims(nonce))
+		}, "failed to verify id token signature"},
+		{"wrong issuer", func(t *testing.T, nonce string) string {
+			claims := idp.claims(nonce)
+			claims["iss"] = "https://evil.example.com"
+			return idp.sign(t, "RS256", "rsa", claims)
+		}, `different provider, expected "` + idp.server.URL + `" got "https://evil.example.com"`},
+		{"wrong audience", func(t *testing.T, nonce string) string {
+			claims := idp.claims(nonce)
+			claims["aud"] = "other"
+			return idp.sign(t, "RS256", "rsa", claims)
+		}, `expected audience "sql-form"`},
+		{"issued to another client", func(t *testing.T, nonce string) string {
+			claims := idp.claims(nonce)
+			claims["azp"] = "other"
+			return idp.sign(t, "RS256", "rsa", claims)
+		}, "issued to other, not this client"},
+		{"several audiences without azp", func(t *testing.T, nonce string) string {
+			claims := idp.claims(nonce)
+			delete(claims, "azp")
+			return idp.sign(t, "RS256", "rsa", claims)
+		}, "doesn't say which it was issued to"},
+		{"nonce mismatch", func(t *testing.T, nonce string) string {
+			return idp.sign(t, "ES256", "ec", idp.claims("another login"))
+		}, "for a different login"},
+		{"expired", func(t *testing.T, nonce string) string {
+			claims := idp.claims(nonce)
+			claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			return idp.sign(t, "RS256", "rsa", claims)
+		}, "token is expired"},
+		{"no username", func(t *testing.T, nonce string) string {
+			claims := idp.claims(nonce)
+			delete(claims, "preferred_username")
+			return idp.sign(t, "RS256", "rsa", claims)
+		}, "didn't give a username"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := idp.middleware(t)
+			cookies, params := idp.login(t, o, "/")
+			idp.idToken = tt.token(t, params.Get("nonce"))
+			w := callback(o, cookies, url.Values{"code": {"the-code"}, "state": {params.Get("state")}})
+			if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("got %d %q, want 401 %q", w.Code, w.Body, tt.want)
+			}
+			if len(w.Result().Cookies()) > 0 {
+				t.Error("the user was logged in")
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	o := idp.middleware(t)
+
+	cookies, params := idp.login(t, o, "/")
+	idp.idToken = idp.sign(t, "RS256", "rsa", idp.claims(params.Get("nonce")))
+	w := callback(o, cookies, url.Values{"code": {"the-code"}, "state": {"another login"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("state mismatch got %d", w.Code)
+	}
+	w = callback(o, nil, url.Values{"code": {"the-code"}, "state": {params.Get("state")}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("no login in the session got %d", w.Code)
+	}
+
+	// a login started longer ago than the timeout
+	state := oidcState{State: "slow", Nonce: "nonce", Verifier: "verifier", Next: "/",
+		Started: time.Now().Add(-oidcLoginTimeout - time.Minute)}
+	b, _ := json.Marshal(state)
+	rec := httptest.NewRecorder()
+	if err := o.sm.New(rec, httptest.NewRequest("GET", "/auth/login", nil), oidcStateKey, b); err != nil {
+		t.Fatal(err)
+	}
+	idp.idToken = idp.sign(t, "RS256", "rsa", idp.claims("nonce"))
+	w = callback(o, rec.Result().Cookies(), url.Values{"code": {"the-code"}, "state": {"slow"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expired login got %d", w.Code)
+	}
+
+	w = callback(o, cookies, url.Values{"error": {"access_denied"}, "error_description": {"no thanks"}})
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "access_denied: no thanks") {
+		t.Errorf("identity provider error got %d %q", w.Code, w.Body)
+	}
+}
+
+func TestOIDCCallbackNext(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	tests := []struct{ next, want string }{
+		{"/test_form/edit/1", "/test_form/edit/1"},
+		{"", "/"},
+		{"https://evil.example.com/", "/"},
+		{"//evil.example.com/", "/"},
+		{`/\evil.example.com/`, "/"},
+		{"javascript:alert(1)", "/"},
+	}
+	for _, tt := range tests {
+		o := idp.middleware(t)
+		cookies, params := idp.login(t, o, tt.next)
+		idp.idToken = idp.sign(t, "RS256", "rsa", idp.claims(params.Get("nonce")))
+		w := callback(o, cookies, url.Values{"code": {"the-code"}, "state": {params.Get("state")}})
+		if w.Code != http.StatusFound || w.Header().Get("Location") != tt.want {
+			t.Errorf("%q: got %d to %q, want %q", tt.next, w.Code, w.Header().Get("Location"), tt.want)
+		}
+	}
+}
+
+func TestOIDCSessionCookie(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	if _, err := newOIDCMiddleware(authConfig{OIDCIssuer: idp.server.URL, OIDCClientID: "sql-form",
+		OIDCRedirectURL: "https://forms.example.com/auth/callback"}, true); err == nil {
+		t.Error("started without a session key")
+	}
+
+	cookies, _ := idp.login(t, idp.middleware(t), "/")
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies", len(cookies))
+	}
+	if c := cookies[0]; !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode {
+		t.Errorf("got HttpOnly %v Secure %v SameSite %v", c.HttpOnly, c.Secure, c.SameSite)
+	}
+}
+
+func TestOIDCLogout(t *testing.T) {
+	idp := newTestIdP(t)
+	defer idp.server.Close()
+	w := httptest.NewRecorder()
+	idp.middleware(t).ServeLogout(w, httptest.NewRequest("GET", "/auth/logout", nil))
+	if w.Code != http.StatusFound || w.Header().Get("Location") != idp.server.URL+"/logout?client_id=sql-form" {
+		t.Errorf("got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// the openapi document describes the api of every form the user can use, generated from the forms
+// table and each form's columns when it's requested, so it's always up to date
+
+// openAPIObject is an object of the openapi document
+type openAPIObject map[string]interface{}
+
+// componentName matches the characters that aren't allowed in the name of a component
+var componentName = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// openAPIRef is a reference to a schema in the document's components
+func openAPIRef(name string) *JSONSchema {
+	return &JSONSchema{Ref: "#/components/schemas/" + name}
+}
+
+// openAPIContent is the json body of a request or response with the schema
+func openAPIContent(description string, schema *JSONSchema) openAPIObject {
+	return openAPIObject{
+		"description": description,
+		"content":     openAPIObject{"application/json": openAPIObject{"schema": schema}},
+	}
+}
+
+// openAPIErrorResponse is a response with the error schema
+func openAPIErrorResponse(description string) openAPIObject {
+	return openAPIContent(description, openAPIRef("Error"))
+}
+
+// openAPIComponents are the schemas shared by every form
+func openAPIComponents() map[string]*JSONSchema {
+	str := &JSONSchema{Type: "string"}
+	field := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{
+		"name":        str,
+		"type":        {Type: "string", Enum: []interface{}{FormText, FormVarChar, FormInteger, FormDecimal, FormMoney, FormFloat, FormBoolean, FormSelect, FormRadio, FormTimeStamp, FormDate, FormLookup, FormMultiSelect, FormGroup, FormFile}},
+		"label":       str,
+		"description": str,
+		"required":    {Type: "boolean"},
+		"readOnly":    {Type: "boolean"},
+		"maxLength":   {Type: "integer"},
+		"min":         str,
+		"max":         str,
+		"pattern":     str,
+		"options": {Type: "array", Items: &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{
+			"value": str,
+			"label": str,
+		}}},
+		"fields": {Type: "array", Items: openAPIRef("Field")},
+	}}
+	return map[string]*JSONSchema{
+		"Error": {Type: "object", Properties: map[string]*JSONSchema{
+			"error":  str,
+			"fields": {Type: "object", Description: "The problem with each invalid field, keyed by name"},
+		}, Required: []string{"error"}},
+		"Field": field,
+		"Form": {Type: "object", Properties: map[string]*JSONSchema{
+			"name":        str,
+			"description": str,
+			"path":        str,
+			"isAdmin":     {Type: "boolean"},
+			"fields":      {Type: "array", Items: openAPIRef("Field")},
+		}},
+	}
+}
+
+// openAPIListParameters are the query parameters of the list of records, the same as the list page
+func openAPIListParameters(frm *Form) []openAPIObject {
+	list := newListQuery(frm)
+	sortable := make([]interface{}, 0)
+	names := make([]string, 0, len(list.columns))
+	for name := range list.columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	params := []openAPIObject{
+		{"name": "page", "in": "query", "schema": &JSONSchema{Type: "integer", Minimum: schemaNumber("1")}},
+		{"name": "q", "in": "query", "description": "Search the text fields", "schema": &JSONSchema{Type: "string"}},
+		{"name": "desc", "in": "query", "description": "Sort in descending order when set",
+			"schema": &JSONSchema{Type: "string"}},
+	}
+	for _, name := range names {
+		if list.CanSort(name) {
+			sortable = append(sortable, name)
+		}
+		params = append(params, openAPIObject{
+			"name": "filter-" + name, "in": "query", "schema": &JSONSchema{Type: "string"},
+		})
+	}
+	params = append(params, openAPIObject{
+		"name": "sort", "in": "query", "schema": &JSONSchema{Type: "string", Enum: sortable},
+	})
+	return params
+}
+
+// openAPIName is the name of the form's operations, unique in the document, and each operation is
+// prefixed differently so their ids are unique too. Its components are the name prefixed with
+// Record_, so they can't clash with the shared ones.
+func openAPIName(frm *Form, schemas map[string]*JSONSchema) string {
+	base := componentName.ReplaceAllString(frm.Path, "_")
+	name := base
+	for i := 2; ; i++ {
+		_, record := schemas["Record_"+name]
+		_, list := schemas["Record_"+name+"List"]
+		_, conflict := schemas["Record_"+name+"Conflict"]
+		if !record && !list && !conflict {
+			return name
+		}
+		name = base + "_" + strconv.Itoa(i)
+	}
+}
+
+// openAPIPaths adds the paths of the form's api to the document, with the schema of its records
+func openAPIPaths(frm *Form, paths openAPIObject, schemas map[string]*JSONSchema) {
+	name := openAPIName(frm, schemas)
+	component := "Record_" + name
+	record := openAPIRef(component)
+	schemas[component] = formSchema(frm)
+	schemas[component+"List"] = &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{
+		"records": {Type: "array", Items: record},
+		"page":    {Type: "integer"},
+		"pages":   {Type: "integer"},
+		"total":   {Type: "integer"},
+	}}
+	schemas[component+"Conflict"] = &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{
+		"error":   {Type: "string"},
+		"current": record,
+	}}
+
+	tags := []string{frm.Name}
+	base := "/forms/" + frm.Path
+	idParam := []openAPIObject{{"name": "id", "in": "path", "required": true, "schema": &JSONSchema{Type: "integer"}}}
+	notFound := openAPIErrorResponse("The record doesn't exist or the user can't see it")
+	paths[base] = openAPIObject{
+		"get": openAPIObject{
+			"tags": tags, "summary": "Describe the " + frm.Name + " form", "description": frm.Description,
+			"operationId": "describe_" + name,
+			"responses":   openAPIObject{"200": openAPIContent("The form and its fields", openAPIRef("Form"))},
+		},
+	}
+	paths[base+"/schema.json"] = openAPIObject{
+		"get": openAPIObject{
+			"tags": tags, "summary": "JSON Schema of the " + frm.Name + " records",
+			"operationId": "schema_" + name,
+			"responses": openAPIObject{"200": openAPIObject{
+				"description": "The schema",
+				"content":     openAPIObject{"application/schema+json": openAPIObject{}},
+			}},
+		},
+	}
+	paths[base+"/records"] = openAPIObject{
+		"get": openAPIObject{
+			"tags": tags, "summary": "List " + frm.Name + " records",
+			"operationId": "list_" + name,
+			"parameters":  openAPIListParameters(frm),
+			"responses":   openAPIObject{"200": openAPIContent("A page of records", openAPIRef(component+"List"))},
+		},
+		"post": openAPIObject{
+			"tags": tags, "summary": "Create a " + frm.Name + " record",
+			"operationId": "create_" + name,
+			"requestBody": openAPIObject{
+				"required": true,
+				"content":  openAPIObject{"application/json": openAPIObject{"schema": record}},
+			},
+			"responses": openAPIObject{
+				"201": openAPIContent("The record that was created", record),
+				"400": openAPIErrorResponse("The body isn't a record of the form"),
+				"422": openAPIErrorResponse("Some of the values are invalid"),
+			},
+		},
+	}
+	paths[base+"/records/{id}"] = openAPIObject{
+		"get": openAPIObject{
+			"tags": tags, "summary": "Read a " + frm.Name + " record",
+			"operationId": "get_" + name,
+			"parameters":  idParam,
+			"responses": openAPIObject{
+				"200": openAPIContent("The record", record),
+				"404": notFound,
+			},
+		},
+		"put": openAPIObject{
+			"tags": tags, "summary": "Update a " + frm.Name + " record",
+			"description": "Only the fields given are changed. With updated_ts, the update is rejected " +
+				"if the record has changed since it was read.",
+			"operationId": "update_" + name,
+			"parameters":  idParam,
+			"requestBody": openAPIObject{
+				"required": true,
+				"content":  openAPIObject{"application/json": openAPIObject{"schema": &JSONSchema{Type: "object"}}},
+			},
+			"responses": openAPIObject{
+				"200": openAPIContent("The updated record", record),
+				"400": openAPIErrorResponse("The body isn't a record of the form"),
+				"404": notFound,
+				"409": openAPIContent("The record has changed since it was read", openAPIRef(component+"Conflict")),
+				"422": openAPIErrorResponse("Some of the values are invalid"),
+			},
+		},
+		"delete": openAPIObject{
+			"tags": tags, "summary": "Delete a " + frm.Name + " record",
+			"operationId": "delete_" + name,
+			"parameters":  idParam,
+			"responses": openAPIObject{
+				"204": openAPIObject{"description": "The record was deleted"},
+				"404": notFound,
+			},
+		},
+	}
+}
+
+// ServeOpenAPI serves the openapi document of the forms the user can use. Forms that can't be loaded
+// are left out, so one broken form doesn't break the document.
+func ServeOpenAPI(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+	catalogue, _, err := userForms(ctx, req)
+	if err != nil {
+		log.Println(err)
+		apiError(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+
+	paths := openAPIObject{}
+	schemas := openAPIComponents()
+	tags := make([]openAPIObject, 0, len(catalogue))
+	for _, entry := range catalogue {
+		frm, err := forms.get(ctx, entry.Path)
+		if err != nil {
+			log.Printf("form %s: %s", entry.Path, err)
+			continue
+		}
+		openAPIPaths(frm, paths, schemas)
+		tags = append(tags, openAPIObject{"name": frm.Name, "description": frm.Description})
+	}
+
+	doc := openAPIObject{
+		"openapi":           "3.1.0",
+		"jsonSchemaDialect": jsonSchemaDialect,
+		"info": openAPIObject{
+			"title":   "Forms",
+			"version": "1",
+		},
+		"servers":    []openAPIObject{{"url": requestBaseURL(req) + "/api/v1"}},
+		"tags":       tags,
+		"paths":      paths,
+		"components": openAPIObject{"schemas": schemas},
+	}
+	// any request can be refused if the user isn't logged in
+	unauthorized := openAPIErrorResponse("The user isn't logged in and the form needs them to be")
+	for _, path := range paths {
+		for _, op := range path.(openAPIObject) {
+			op.(openAPIObject)["responses"].(openAPIObject)["401"] = unauthorized
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// FieldOption is one of the choices for a select or radio field
+type FieldOption struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+const (
+	optionsTablePrefix = "table:"
+	optionsQueryPrefix = "query:"
+)
+
+// resolveOptions reads the options for the field from the options column of the labels table, which is one of:
+//
+//   - a list of options separated by commas, or by new lines if the labels need commas. Each option is
+//     either the value to save, or value|label to show a different label.
+//   - table:lookup_table(value_column, label_column) to use the rows of another table, sorted by label.
+//     The label column can be left out if it's the same as the value.
+//   - query:SELECT value, label FROM ... a query returning the values and labels. Any placeholders are
+//     bound to the comma separated values in the optional options_params column of the labels table.
+func resolveOptions(ctx context.Context, spec string, params string) ([]FieldOption, error) {
+	switch {
+	case strings.HasPrefix(spec, optionsTablePrefix):
+		query, err := optionsTableQuery(strings.TrimPrefix(spec, optionsTablePrefix))
+		if err != nil {
+			return nil, err
+		}
+		return queryOptions(ctx, query)
+	case strings.HasPrefix(spec, optionsQueryPrefix):
+		args := make([]interface{}, 0)
+		if params != "" {
+			for _, p := range strings.Split(params, ",") {
+				args = append(args, strings.TrimSpace(p))
+			}
+		}
+		return queryOptions(ctx, strings.TrimPrefix(spec, optionsQueryPrefix), args...)
+	}
+	return parseOptionsList(spec), nil
+}
+
+// parseOptionsList reads a list of value or value|label options
+func parseOptionsList(spec string) []FieldOption {
+	sep := ","
+	if strings.Contains(spec, "\n") {
+		sep = "\n"
+	}
+	options := make([]FieldOption, 0)
+	for _, opt := range strings.Split(spec, sep) {
+		opt = strings.TrimSpace(opt)
+		if opt == "" {
+			continue
+		}
+		value, label := opt, opt
+		if i := strings.Index(opt, "|"); i >= 0 {
+			value, label = strings.TrimSpace(opt[:i]), strings.TrimSpace(opt[i+1:])
+		}
+		options = append(options, FieldOption{Value: value, Label: label})
+	}
+	return options
+}
+
+// optionsTableQuery builds the query for a lookup_table(value_column, label_column) reference
+func optionsTableQuery(ref string) (string, error) {
+	open := strings.Index(ref, "(")
+	if open < 0 || !strings.HasSuffix(ref, ")") {
+		return "", errors.Errorf("options table %s should be in the form table(value_column, label_column)", ref)
+	}
+	table := strings.TrimSpace(ref[:open])
+	cols := strings.Split(ref[open+1:len(ref)-1], ",")
+	if table == "" || len(cols) > 2 || strings.TrimSpace(cols[0]) == "" {
+		return "", errors.Errorf("options table %s should be in the form table(value_column, label_column)", ref)
+	}
+	valueCol := dialect.QuoteIdent(strings.TrimSpace(cols[0]))
+	labelCol := valueCol
+	if len(cols) == 2 {
+		labelCol = dialect.QuoteIdent(strings.TrimSpace(cols[1]))
+	}
+	return fmt.Sprintf("SELECT %s, %s FROM %s ORDER BY %s", valueCol, labelCol, quoteTable(table), labelCol), nil
+}
+
+// queryOptions runs a query returning either value, label or just value rows
+func queryOptions(ctx context.Context, query string, args ...interface{}) ([]FieldOption, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query options")
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		_ = rows.Close()
+		return nil, errors.Wrap(err, "unable to read options columns")
+	}
+
+	options := make([]FieldOption, 0)
+	for rows.Next() {
+		var value, label sql.NullString
+		if len(cols) == 1 {
+			err = rows.Scan(&value)
+			label = value
+		} else {
+			err = rows.Scan(&value, &label)
+		}
+		if err != nil {
+			_ = rows.Close()
+			return nil, errors.Wrap(err, "unable to read options, the query should return the value then label")
+		}
+		options = append(options, FieldOption{Value: value.String, Label: label.String})
+	}
+	if err := rows.Close(); err != nil {
+		return nil, errors.Wrap(err, "unable to close options rows")
+	}
+	return options, nil
+}
+
+// hasOption checks the value is one of the field's options
+func (f *FormField) hasOption(value string) bool {
+	for _, opt := range f.Options {
+		if opt.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// OptionLabel returns the label shown for the value, or the value itself if it isn't one of the options.
+// The labels of each of the values of a multi-select are joined with commas.
+func (f *FormField) OptionLabel(value string) string {
+	if f.FieldType == FormMultiSelect {
+		vals := splitMultiValue(value)
+		labels := make([]string, 0, len(vals))
+		for _, v := range vals {
+			labels = append(labels, f.optionLabel(v))
+		}
+		return strings.Join(labels, ", ")
+	}
+	return f.optionLabel(value)
+}
+
+func (f *FormField) optionLabel(value string) string {
+	for _, opt := range f.Options {
+		if opt.Value == value {
+			return opt.Label
+		}
+	}
+	return value
+}
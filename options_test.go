@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func TestLoadFieldOptionsFailure(t *testing.T) {
+	dialect = sqliteDialect{}
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	saved := db
+	db = conn
+	defer func() { db = saved }()
+
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{"malformed table", "table:colours"},
+		{"missing table", "table:colours(id, name)"},
+		{"query error", "query:SELECT value, label FROM nowhere"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			field := &FormField{Name: "colour", FieldType: FormSelect, Required: true, optionsSpec: tt.spec}
+			if err := loadFieldOptions(context.Background(), field); err == nil {
+				t.Fatal("expected an error")
+			}
+			// the form can still be submitted
+			if field.FieldType != FormVarChar {
+				t.Errorf("got %v, want a text field", field.FieldType)
+			}
+			if got, err := parseFieldValue(field, "Red", "+00:00"); err != nil || got != "Red" {
+				t.Errorf("got %v %v", got, err)
+			}
+		})
+	}
+
+	radio := &FormField{Name: "colour", FieldType: FormRadio, optionsSpec: "Red,Green"}
+	if err := loadFieldOptions(context.Background(), radio); err != nil {
+		t.Fatal(err)
+	}
+	if radio.FieldType != FormRadio || len(radio.Options) != 2 {
+		t.Errorf("got %v with %v", radio.FieldType, radio.Options)
+	}
+}
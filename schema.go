@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// jsonSchemaDialect is the version of JSON Schema the form schemas are written in, the same as
+// OpenAPI 3.1 uses
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema is the part of JSON Schema used to describe the records of a form, as they're read and
+// written by the api
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Enum                 []interface{}          `json:"enum,omitempty"`
+	MinLength            int                    `json:"minLength,omitempty"`
+	MaxLength            int                    `json:"maxLength,omitempty"`
+	Minimum              *json.Number           `json:"minimum,omitempty"`
+	Maximum              *json.Number           `json:"maximum,omitempty"`
+	ReadOnly             bool                   `json:"readOnly,omitempty"`
+	UniqueItems          bool                   `json:"uniqueItems,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+}
+
+// schemaTimestamp matches both the timestamps the api returns and RFC 3339 ones, which it also accepts
+const schemaTimestamp = `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}`
+
+var htmlTags = regexp.MustCompile(`<[^>]*>`)
+
+// formSchema describes the records of the form. Fields that aren't required can be null, the id,
+// files and LDAP populated fields are read only.
+func formSchema(frm *Form) *JSONSchema {
+	schema := recordSchema(frm.Fields)
+	schema.Title = frm.Name
+	schema.Description = frm.Description
+	schema.Properties["id"] = &JSONSchema{Type: "integer", ReadOnly: true}
+	schema.Properties["updated_ts"] = &JSONSchema{Type: "string", Description: "The version of the " +
+		"record that was read, an update is rejected if the record has changed since."}
+	schema.Properties["created_user"] = &JSONSchema{Type: "string", ReadOnly: true}
+	schema.Properties["created_ts"] = &JSONSchema{Type: "string", Pattern: schemaTimestamp, ReadOnly: true}
+	return schema
+}
+
+// recordSchema is an object with a property for each field, used for records and the rows of groups
+func recordSchema(fields []*FormField) *JSONSchema {
+	noOther := false
+	schema := &JSONSchema{
+		Type:                 "object",
+		Properties:           make(map[string]*JSONSchema),
+		AdditionalProperties: &noOther,
+	}
+	for _, field := range fields {
+		schema.Properties[field.Name] = fieldSchema(field)
+		if field.Required && !field.IsLDAPPopulated && field.FieldType != FormFile {
+			schema.Required = append(schema.Required, field.Name)
+		}
+	}
+	return schema
+}
+
+// fieldSchema describes the field's value, in the same types apiValue returns
+func fieldSchema(field *FormField) *JSONSchema {
+	s := &JSONSchema{
+		Title:       field.Label,
+		Description: html.UnescapeString(htmlTags.ReplaceAllString(string(field.Description), "")),
+		ReadOnly:    field.IsLDAPPopulated,
+	}
+	schemaType := "string"
+	switch field.FieldType {
+	case FormInteger:
+		schemaType = "integer"
+	case FormDecimal, FormMoney, FormFloat:
+		schemaType = "number"
+	case FormBoolean:
+		// unchecked is false rather than null
+		s.Type = "boolean"
+		return s
+	case FormMultiSelect:
+		s.Type = "array"
+		s.UniqueItems = true
+		s.Items = &JSONSchema{Type: "string", Enum: schemaOptions(field)}
+		return s
+	case FormGroup:
+		rows := recordSchema(field.Group.Fields)
+		rows.Properties["id"] = &JSONSchema{Type: []string{"integer", "null"},
+			Description: "The id of an existing row, which is kept. Rows without an id are added."}
+		s.Type = "array"
+		s.Items = rows
+		return s
+	case FormFile:
+		s.ReadOnly = true
+		if field.MultipleFiles() {
+			s.Type = "array"
+			s.Items = &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{
+				"id":   {Type: "string"},
+				"name": {Type: "string"},
+				"url":  {Type: "string", Format: "uri-reference"},
+			}}
+			return s
+		}
+		s.Format = "uri-reference"
+	case FormTimeStamp:
+		s.Pattern = schemaTimestamp
+	case FormDate:
+		s.Format = "date"
+	case FormSelect, FormRadio:
+		s.Enum = schemaOptions(field)
+	}
+
+	switch schemaType {
+	case "string":
+		if field.MaxLength > 0 {
+			s.MaxLength = field.MaxLength
+		}
+		if field.regex != nil && s.Pattern == "" {
+			s.Pattern = field.regex.String()
+		}
+		if field.Required && s.Enum == nil && s.Format == "" {
+			// an empty value is missing
+			s.MinLength = 1
+		}
+	default:
+		s.Minimum = schemaNumber(field.Min)
+		s.Maximum = schemaNumber(field.Max)
+	}
+	if field.Required && field.FieldType != FormFile {
+		s.Type = schemaType
+	} else {
+		s.Type = []string{schemaType, "null"}
+		if s.Enum != nil {
+			s.Enum = append(s.Enum, nil)
+		}
+	}
+	return s
+}
+
+// schemaOptions are the values of the field's options, nil for lookups, whose options aren't listed
+func schemaOptions(field *FormField) []interface{} {
+	if field.Options == nil {
+		return nil
+	}
+	out := make([]interface{}, 0, len(field.Options))
+	for _, opt := range field.Options {
+		out = append(out, opt.Value)
+	}
+	return out
+}
+
+// schemaNumber is the min or max of a number field, nil if it isn't set
+func schemaNumber(val string) *json.Number {
+	if _, err := strconv.ParseFloat(val, 64); err != nil {
+		return nil
+	}
+	n := json.Number(val)
+	return &n
+}
+
+// ServeAPISchema serves the JSON Schema of the form's records, for validating them before they're sent
+func ServeAPISchema(w http.ResponseWriter, req *http.Request) {
+	frm, _, ok := apiForm(w, req)
+	if !ok {
+		return
+	}
+	schema := formSchema(frm)
+	schema.Schema = jsonSchemaDialect
+	schema.ID = requestBaseURL(req) + "/api/v1/forms/" + frm.Path + "/schema.json"
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(schema); err != nil {
+		log.Println(err)
+	}
+}
+
+// requestBaseURL is the scheme and host the request was made to
+func requestBaseURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host
+}
@@ -4,6 +4,7 @@ DROP TABLE IF EXISTS test_form;
 DROP TABLE IF EXISTS test_form_colours;
 DROP TABLE IF EXISTS test_form_labels;
 DROP TABLE IF EXISTS forms;
+DROP TABLE IF EXISTS form_audit;
 
 CREATE TABLE test_form_labels
 (
@@ -75,3 +76,18 @@ CREATE TABLE forms
 INSERT INTO forms (name, description, path, table_name, admins, allow_anonymous, use_ldap_fields)
 VALUES ('Test Form', 'This is a test form', 'test_form', 'test_form', '', 1, 1);
 
+-- optional, every insert and update of a form is recorded here if it exists
+CREATE TABLE form_audit
+(
+    id         INT           NOT NULL IDENTITY PRIMARY KEY,
+    form_path  VARCHAR(254)  NOT NULL,
+    record_id  INT           NOT NULL,
+    username   VARCHAR(254)  NOT NULL,
+    changed_ts DATETIMEOFFSET NOT NULL,
+    action     VARCHAR(20)   NOT NULL,
+    -- a JSON list of the fields that changed, with their values before and after
+    changes    NVARCHAR(MAX) NOT NULL
+);
+
+CREATE INDEX form_audit_record ON form_audit (form_path, record_id);
+
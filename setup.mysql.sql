@@ -0,0 +1,94 @@
+-- Example setup file for MySQL / MariaDB
+
+DROP TABLE IF EXISTS test_form;
+DROP TABLE IF EXISTS test_form_colours;
+DROP TABLE IF EXISTS test_form_labels;
+DROP TABLE IF EXISTS forms;
+DROP TABLE IF EXISTS form_audit;
+
+CREATE TABLE test_form_labels
+(
+    column_name        VARCHAR(254)  NOT NULL PRIMARY KEY,
+    label              VARCHAR(254)  NOT NULL,
+    description        TEXT          NOT NULL,
+    placeholder        VARCHAR(254)  NOT NULL,
+    section_heading    VARCHAR(254)  NOT NULL,
+    options            VARCHAR(1024) NOT NULL,
+    options_as_radio   BOOLEAN       NOT NULL,
+    -- Regex only works for some types
+    regex              VARCHAR(1024) NOT NULL,
+    linebreak_after    BOOLEAN       NOT NULL,
+    include_in_summary BOOLEAN       NOT NULL
+);
+
+INSERT INTO test_form_labels
+VALUES ('name', 'Customer Name', '', '', '', '', false, '', false, true),
+       ('description', 'Description', 'Some extra *details* about __the customer__', '', '', '', true, '', true, false),
+       ('age', 'Age of the customer', '', '', 'Customer Details', '', false, '', false, true),
+       ('colour', 'Fav colour', '', '', '', 'Red,Green,Blue', true, '', false, true);
+
+CREATE TABLE test_form
+(
+    id                      INT           NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    created_ts              TIMESTAMP(6) NOT NULL,
+    updated_ts              TIMESTAMP(6) NOT NULL,
+    created_user            VARCHAR(254)  NOT NULL,
+    -- these are fields that can be used with LDAP integration -----
+    user_employee_number    VARCHAR(1024) NOT NULL,
+    user_display_name       VARCHAR(1024) NOT NULL,
+    user_department         VARCHAR(1024) NOT NULL,
+    user_email              VARCHAR(1024) NOT NULL,
+    user_location           VARCHAR(1024) NOT NULL,
+    manager                 VARCHAR(1024) NOT NULL,
+    manager_employee_number VARCHAR(1024) NOT NULL,
+    manager_display_name    VARCHAR(1024) NOT NULL,
+    manager_department      VARCHAR(1024) NOT NULL,
+    manager_email           VARCHAR(1024) NOT NULL,
+    manager_location        VARCHAR(1024) NOT NULL,
+    -- below is flexible -----
+    name                    VARCHAR(1024) NOT NULL,
+    description             TEXT,
+    age                     INT           NOT NULL,
+    height                  INT,
+    -- there is no money type, use a DECIMAL with 2 places
+    sales_value             DECIMAL(19, 2),
+    fixed                   DECIMAL(19, 4),
+    fraction_complete       DOUBLE,
+    colour                  VARCHAR(1024) NOT NULL,
+    -- Bools can't be not null, BOOLEAN is an alias for TINYINT(1)
+    is_active               BOOLEAN       NOT NULL,
+    pickup_scheduled        DATETIME      NULL,
+    dob                     DATE          NOT NULL
+);
+
+
+CREATE TABLE forms
+(
+    form_id         INT           NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name            VARCHAR(254)  NOT NULL,
+    description     TEXT          NOT NULL,
+    path            VARCHAR(254)  NOT NULL UNIQUE,
+    table_name      VARCHAR(254)  NOT NULL,
+    admins          VARCHAR(1024) NOT NULL,
+    allow_anonymous BOOLEAN       NOT NULL,
+    use_ldap_fields BOOLEAN       NOT NULL
+);
+
+INSERT INTO forms (name, description, path, table_name, admins, allow_anonymous, use_ldap_fields)
+VALUES ('Test Form', 'This is a test form', 'test_form', 'test_form', '', true, true);
+
+-- optional, every insert and update of a form is recorded here if it exists
+CREATE TABLE form_audit
+(
+    id         INT           NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    form_path  VARCHAR(254)  NOT NULL,
+    record_id  INT           NOT NULL,
+    username   VARCHAR(254)  NOT NULL,
+    changed_ts DATETIME      NOT NULL,
+    action     VARCHAR(20)   NOT NULL,
+    -- a JSON list of the fields that changed, with their values before and after
+    changes    LONGTEXT      NOT NULL
+);
+
+CREATE INDEX form_audit_record ON form_audit (form_path, record_id);
+
@@ -4,6 +4,7 @@ DROP TABLE IF EXISTS test_form;
 DROP TABLE IF EXISTS test_form_colours;
 DROP TABLE IF EXISTS test_form_labels;
 DROP TABLE IF EXISTS forms;
+DROP TABLE IF EXISTS form_audit;
 
 CREATE TABLE test_form_labels
 (
@@ -75,3 +76,49 @@ CREATE TABLE forms
 INSERT INTO forms (name, description, path, table_name, admins, allow_anonymous, use_ldap_fields)
 VALUES ('Test Form', 'This is a test form', 'test_form', 'test_form', '', true, true);
 
+-- optional, every insert and update of a form is recorded here if it exists
+CREATE TABLE form_audit
+(
+    id         SERIAL        NOT NULL PRIMARY KEY,
+    form_path  TEXT          NOT NULL,
+    record_id  INT           NOT NULL,
+    username   TEXT          NOT NULL,
+    changed_ts TIMESTAMPTZ   NOT NULL,
+    action     VARCHAR(20)   NOT NULL,
+    -- a JSON list of the fields that changed, with their values before and after
+    changes    TEXT          NOT NULL
+);
+
+CREATE INDEX form_audit_record ON form_audit (form_path, record_id);
+
+-- Optional: notify the server when a form definition changes so its cached copy is reloaded.
+-- Add a labels trigger for each form's _labels table.
+CREATE OR REPLACE FUNCTION notify_form_changed() RETURNS TRIGGER AS
+$$
+BEGIN
+    IF TG_TABLE_NAME = 'forms' THEN
+        IF TG_OP <> 'INSERT' THEN
+            PERFORM pg_notify('form_changed', OLD.path);
+        END IF;
+        IF TG_OP <> 'DELETE' THEN
+            PERFORM pg_notify('form_changed', NEW.path);
+        END IF;
+    ELSE
+        PERFORM pg_notify('form_changed', TG_TABLE_SCHEMA || '.' || TG_TABLE_NAME);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER forms_changed
+    AFTER INSERT OR UPDATE OR DELETE
+    ON forms
+    FOR EACH ROW
+EXECUTE PROCEDURE notify_form_changed();
+
+CREATE TRIGGER test_form_labels_changed
+    AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE
+    ON test_form_labels
+    FOR EACH STATEMENT
+EXECUTE PROCEDURE notify_form_changed();
+
@@ -0,0 +1,94 @@
+-- Example setup file for SQLite
+-- e.g. sqlite3 forms.db < setup.sqlite.sql
+
+DROP TABLE IF EXISTS test_form;
+DROP TABLE IF EXISTS test_form_colours;
+DROP TABLE IF EXISTS test_form_labels;
+DROP TABLE IF EXISTS forms;
+DROP TABLE IF EXISTS form_audit;
+
+CREATE TABLE test_form_labels
+(
+    column_name        TEXT    NOT NULL PRIMARY KEY,
+    label              TEXT    NOT NULL,
+    description        TEXT    NOT NULL,
+    placeholder        TEXT    NOT NULL,
+    section_heading    TEXT    NOT NULL,
+    options            TEXT    NOT NULL,
+    options_as_radio   BOOLEAN NOT NULL,
+    -- Regex only works for some types
+    regex              TEXT    NOT NULL,
+    linebreak_after    BOOLEAN NOT NULL,
+    include_in_summary BOOLEAN NOT NULL
+);
+
+INSERT INTO test_form_labels
+VALUES ('name', 'Customer Name', '', '', '', '', false, '', false, true),
+       ('description', 'Description', 'Some extra *details* about __the customer__', '', '', '', true, '', true, false),
+       ('age', 'Age of the customer', '', '', 'Customer Details', '', false, '', false, true),
+       ('colour', 'Fav colour', '', '', '', 'Red,Green,Blue', true, '', false, true);
+
+CREATE TABLE test_form
+(
+    id                      INTEGER   NOT NULL PRIMARY KEY,
+    created_ts              TIMESTAMP NOT NULL,
+    updated_ts              TIMESTAMP NOT NULL,
+    created_user            VARCHAR   NOT NULL,
+    -- these are fields that can be used with LDAP integration -----
+    user_employee_number    VARCHAR   NOT NULL,
+    user_display_name       VARCHAR   NOT NULL,
+    user_department         VARCHAR   NOT NULL,
+    user_email              VARCHAR   NOT NULL,
+    user_location           VARCHAR   NOT NULL,
+    manager                 VARCHAR   NOT NULL,
+    manager_employee_number VARCHAR   NOT NULL,
+    manager_display_name    VARCHAR   NOT NULL,
+    manager_department      VARCHAR   NOT NULL,
+    manager_email           VARCHAR   NOT NULL,
+    manager_location        VARCHAR   NOT NULL,
+    -- below is flexible -----
+    name                    VARCHAR   NOT NULL,
+    description             TEXT,
+    age                     INTEGER   NOT NULL,
+    height                  INTEGER,
+    sales_value             MONEY,
+    fixed                   DECIMAL,
+    fraction_complete       FLOAT,
+    colour                  VARCHAR   NOT NULL,
+    -- Bools can't be not null
+    is_active               BOOLEAN   NOT NULL,
+    pickup_scheduled        TIMESTAMP NULL,
+    dob                     DATE      NOT NULL
+);
+
+
+CREATE TABLE forms
+(
+    form_id         INTEGER NOT NULL PRIMARY KEY,
+    name            TEXT    NOT NULL,
+    description     TEXT    NOT NULL,
+    path            TEXT    NOT NULL UNIQUE,
+    table_name      TEXT    NOT NULL,
+    admins          TEXT    NOT NULL,
+    allow_anonymous BOOLEAN NOT NULL,
+    use_ldap_fields BOOLEAN NOT NULL
+);
+
+INSERT INTO forms (name, description, path, table_name, admins, allow_anonymous, use_ldap_fields)
+VALUES ('Test Form', 'This is a test form', 'test_form', 'test_form', '', true, true);
+
+-- optional, every insert and update of a form is recorded here if it exists
+CREATE TABLE form_audit
+(
+    id         INTEGER       NOT NULL PRIMARY KEY,
+    form_path  TEXT          NOT NULL,
+    record_id  INT           NOT NULL,
+    username   TEXT          NOT NULL,
+    changed_ts TIMESTAMP     NOT NULL,
+    action     VARCHAR(20)   NOT NULL,
+    -- a JSON list of the fields that changed, with their values before and after
+    changes    TEXT          NOT NULL
+);
+
+CREATE INDEX form_audit_record ON form_audit (form_path, record_id);
+
@@ -19,12 +19,20 @@ type SessionMgr struct {
 	cookieName string
 }
 
-func NewSessionMgr(sessionKey string, cookieName string) SessionMgr {
+// NewSessionMgr keeps the session in a cookie signed with the key, only sent over https when secure
+// is set
+func NewSessionMgr(sessionKey string, cookieName string, secure bool) SessionMgr {
 	// Best practice is to load this key from a secure location.
 	skey := []byte(sessionKey)
+	store := sessions.NewCookieStore(skey)
+	// scripts can't read the cookie, and other sites can't post with it, but following a link (or the
+	// identity provider's redirect back) to the site keeps the user logged in
+	store.Options.HttpOnly = true
+	store.Options.Secure = secure
+	store.Options.SameSite = http.SameSiteLaxMode
 	return SessionMgr{
 		skey:       skey,
-		store:      sessions.NewCookieStore(skey),
+		store:      store,
 		cookieName: cookieName,
 	}
 }
@@ -53,13 +61,24 @@ func (smgr SessionMgr) New(w http.ResponseWriter, r *http.Request, k string, v [
 	return s.Save(r, w)
 }
 
+// Clear removes the session cookie, logging the user out
+func (smgr SessionMgr) Clear(w http.ResponseWriter, r *http.Request) error {
+	s, err := smgr.store.New(r, smgr.cookieName)
+	if err != nil && s == nil {
+		return fmt.Errorf("could not get session from session manager: %v", err)
+	}
+	s.Values = make(map[interface{}]interface{})
+	s.Options.MaxAge = -1
+	return s.Save(r, w)
+}
+
 func (sm *spnegoMiddleware) Middleware(next http.Handler) http.Handler {
 	l := log.New(os.Stderr, "GOKRB5 Service: ", log.Ldate|log.Ltime|log.Lshortfile)
 	return spnego.SPNEGOKRB5Authenticate(
 		next,
 		sm.kt,
 		service.Logger(l),
-		service.SessionManager(NewSessionMgr(sm.sessionKey, sm.cookieName)),
+		service.SessionManager(NewSessionMgr(sm.sessionKey, sm.cookieName, sm.secure)),
 	)
 }
 
@@ -67,9 +86,10 @@ type spnegoMiddleware struct {
 	kt         *keytab.Keytab
 	sessionKey string
 	cookieName string
+	secure     bool
 }
 
-func spnegoFromKeytab(keytabFilename string, sessionKey string, cookieName string) (*spnegoMiddleware, error) {
+func spnegoFromKeytab(keytabFilename string, sessionKey string, cookieName string, secure bool) (*spnegoMiddleware, error) {
 	b, err := ioutil.ReadFile(keytabFilename)
 	if err != nil {
 		return nil, err
@@ -83,6 +103,7 @@ func spnegoFromKeytab(keytabFilename string, sessionKey string, cookieName strin
 		kt:         kt,
 		sessionKey: sessionKey,
 		cookieName: cookieName,
+		secure:     secure,
 	}
 	return &sm, nil
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/shopspring/decimal"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldErrors maps a field name to a message explaining what is wrong with the submitted value
+type FieldErrors map[string]string
+
+// ValidationError is returned when some of the submitted values are invalid, so the form can be
+// shown again with the problems highlighted rather than losing what the user entered
+type ValidationError struct {
+	Fields FieldErrors
+}
+
+func (e *ValidationError) Error() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, 0, len(names))
+	for _, name := range names {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", name, e.Fields[name]))
+	}
+	return "invalid values for " + strings.Join(msgs, ", ")
+}
+
+// submittedValues reads the raw form values for each field from the request, along with the id,
+// updated_ts and timezone offset, in the same shape as loadFormEntry so they can be shown in the form again
+func submittedValues(frm *Form, req *http.Request) (map[string]string, groupRows) {
+	vals := make(map[string]string)
+	vals["id"] = req.FormValue("id")
+	vals["timezone-offset"] = req.FormValue("timezone-offset")
+	vals["updated_ts"] = req.FormValue("updated_ts")
+	for _, field := range frm.Fields {
+		if field.FieldType == FormMultiSelect {
+			// each checked box is submitted as a separate value
+			vals[field.Name] = strings.Join(req.Form[field.Name], multiValueSeparator)
+			continue
+		}
+		vals[field.Name] = req.FormValue(field.Name)
+	}
+	return vals, submittedGroupRows(frm, req)
+}
+
+// validateSubmission parses and checks each of the submitted values, returning the values to save
+// keyed by field name. LDAP populated fields are not included, they don't come from the user.
+// The rows of groups are returned as a []groupRow.
+func validateSubmission(ctx context.Context, frm *Form, vals map[string]string, rows groupRows) (map[string]interface{}, error) {
+	tzOffset := minOffsetToTZOffset(vals["timezone-offset"])
+	out := make(map[string]interface{})
+	fieldErrs := make(FieldErrors)
+	for _, field := range frm.Fields {
+		// files are read separately, by submittedFiles
+		if field.IsLDAPPopulated || field.FieldType == FormFile {
+			continue
+		}
+		if field.Group != nil {
+			parsed, err := validateGroupRows(ctx, field, rows[field.Name], tzOffset, fieldErrs)
+			if err != nil {
+				return nil, err
+			}
+			out[field.Name] = parsed
+			continue
+		}
+		val, msg, err := checkFieldValue(ctx, field, vals[field.Name], tzOffset)
+		if err != nil {
+			return nil, err
+		}
+		if msg != "" {
+			fieldErrs[field.Name] = msg
+			continue
+		}
+		out[field.Name] = val
+	}
+	if len(fieldErrs) > 0 {
+		return nil, &ValidationError{Fields: fieldErrs}
+	}
+	return out, nil
+}
+
+// checkFieldValue parses the value with parseFieldValue, and checks lookup values exist in the
+// referenced table. If the value is invalid the message explains why.
+func checkFieldValue(ctx context.Context, field *FormField, value string, tzOffset string) (interface{}, string, error) {
+	val, err := parseFieldValue(field, value, tzOffset)
+	if err != nil {
+		return nil, err.Error(), nil
+	}
+	if field.FieldType == FormLookup && val != nil {
+		opt, err := findLookupOption(ctx, field.lookup, value)
+		if err != nil {
+			return nil, "", err
+		}
+		if opt == nil {
+			return nil, "Not one of the available options", nil
+		}
+	}
+	return val, "", nil
+}
+
+// parseFieldValue checks the submitted value for the field, returning the value to save. Any error
+// is a message for the user explaining what's wrong with the value.
+func parseFieldValue(field *FormField, value string, tzOffset string) (interface{}, error) {
+	// bools can't be not null, an unchecked box is just false
+	if field.FieldType == FormBoolean {
+		return value == "1", nil
+	}
+
+	// any empty strings entered into NULL fields are converted to NULL
+	if value == "" {
+		if field.Required {
+			return nil, errors.New("This field is required")
+		}
+		return nil, nil
+	}
+
+	if field.FieldType == FormMultiSelect {
+		selected := splitMultiValue(value)
+		for _, v := range selected {
+			if !field.hasOption(v) {
+				return nil, errors.Errorf("%s is not one of the available options", v)
+			}
+		}
+		if field.multiStorage == delimitedStorage {
+			if field.MaxLength > 0 && utf8.RuneCountInString(value) > field.MaxLength {
+				return nil, errors.New("Too many options are selected")
+			}
+		}
+		return selected, nil
+	}
+
+	if field.MaxLength > 0 && utf8.RuneCountInString(value) > field.MaxLength {
+		return nil, errors.Errorf("Must be at most %d characters", field.MaxLength)
+	}
+
+	if field.regex != nil && !field.regex.MatchString(value) {
+		return nil, errors.New("Not in the expected format")
+	}
+
+	switch field.FieldType {
+	case FormSelect, FormRadio:
+		if !field.hasOption(value) {
+			return nil, errors.New("Not one of the available options")
+		}
+		return value, nil
+	case FormInteger:
+		val, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New("Must be a whole number")
+		}
+		return val, checkDecimalRange(field, decimal.New(int64(val), 0))
+	case FormDecimal, FormMoney:
+		val, err := decimal.NewFromString(value)
+		if err != nil {
+			if field.FieldType == FormMoney {
+				return nil, errors.New("Must be an amount, e.g. 10.50")
+			}
+			return nil, errors.New("Must be a number")
+		}
+		return val, checkDecimalRange(field, val)
+	case FormFloat:
+		val, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errors.New("Must be a number")
+		}
+		return val, checkDecimalRange(field, decimal.NewFromFloat(val))
+	case FormTimeStamp:
+		val, err := parseTimestamp(value, tzOffset)
+		if err != nil {
+			return nil, errors.New("Must be a date and time")
+		}
+		// the range is in the user's local time, the same as the value they entered
+		local, _ := parseTimestamp(value, "+00:00")
+		return val, checkTimeRange(field, local, DateTimeLocal, "Jan 2, 2006 3:04 PM")
+	case FormDate:
+		val, err := time.Parse(DateLocal, value)
+		if err != nil {
+			return nil, errors.New("Must be a date")
+		}
+		return value, checkTimeRange(field, val, DateLocal, "Jan 2, 2006")
+	}
+	return value, nil
+}
+
+// checkDecimalRange checks the value against the field's min and max, if they are set
+func checkDecimalRange(field *FormField, val decimal.Decimal) error {
+	if min, err := decimal.NewFromString(field.Min); err == nil && val.LessThan(min) {
+		return errors.Errorf("Must be at least %s", field.Min)
+	}
+	if max, err := decimal.NewFromString(field.Max); err == nil && val.GreaterThan(max) {
+		return errors.Errorf("Must be at most %s", field.Max)
+	}
+	return nil
+}
+
+// checkTimeRange checks the value against the field's min and max, if they are set in the given layout
+func checkTimeRange(field *FormField, val time.Time, layout string, display string) error {
+	if min, err := time.Parse(layout, field.Min); err == nil && val.Before(min) {
+		return errors.Errorf("Must be on or after %s", min.Format(display))
+	}
+	if max, err := time.Parse(layout, field.Max); err == nil && val.After(max) {
+		return errors.Errorf("Must be on or before %s", max.Format(display))
+	}
+	return nil
+}
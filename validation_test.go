@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"github.com/shopspring/decimal"
+	"testing"
+	"time"
+)
+
+func TestParseFieldValue(t *testing.T) {
+	code := &FormField{Name: "code", FieldType: FormVarChar, Regex: "[A-Z]{3}"}
+	if err := compileFieldRegex(code); err != nil {
+		t.Fatal(err)
+	}
+	colour := &FormField{Name: "colour", FieldType: FormSelect,
+		Options: []FieldOption{{Value: "R", Label: "Red"}, {Value: "G", Label: "Green"}}}
+	toppings := &FormField{Name: "toppings", FieldType: FormMultiSelect, multiStorage: delimitedStorage, MaxLength: 10,
+		Options: []FieldOption{{Value: "cheese"}, {Value: "ham"}, {Value: "pineapple"}}}
+
+	tests := []struct {
+		name    string
+		field   *FormField
+		value   string
+		want    interface{}
+		wantErr string
+	}{
+		{"empty optional is null", &FormField{FieldType: FormVarChar}, "", nil, ""},
+		{"empty required", &FormField{FieldType: FormVarChar, Required: true}, "", nil, "This field is required"},
+		{"unchecked required box is false", &FormField{FieldType: FormBoolean, Required: true}, "", false, ""},
+		{"checked box", &FormField{FieldType: FormBoolean}, "1", true, ""},
+		{"text", &FormField{FieldType: FormText}, "hello", "hello", ""},
+		{"too long", &FormField{FieldType: FormVarChar, MaxLength: 3}, "abcd", nil, "Must be at most 3 characters"},
+		{"length counts characters", &FormField{FieldType: FormVarChar, MaxLength: 3}, "ééé", "ééé", ""},
+		{"regex matches", code, "ABC", "ABC", ""},
+		{"regex must match the whole value", code, "ABCD", nil, "Not in the expected format"},
+		{"integer", &FormField{FieldType: FormInteger}, "42", 42, ""},
+		{"integer not a number", &FormField{FieldType: FormInteger}, "4.2", nil, "Must be a whole number"},
+		{"integer below min", &FormField{FieldType: FormInteger, Min: "1"}, "0", nil, "Must be at least 1"},
+		{"integer above max", &FormField{FieldType: FormInteger, Max: "120"}, "121", nil, "Must be at most 120"},
+		{"integer at max", &FormField{FieldType: FormInteger, Max: "120"}, "120", 120, ""},
+		{"decimal", &FormField{FieldType: FormDecimal}, "1.25", decimal.RequireFromString("1.25"), ""},
+		{"decimal not a number", &FormField{FieldType: FormDecimal}, "abc", nil, "Must be a number"},
+		{"money not a number", &FormField{FieldType: FormMoney}, "$5", nil, "Must be an amount, e.g. 10.50"},
+		{"float", &FormField{FieldType: FormFloat}, "0.5", 0.5, ""},
+		{"float above max", &FormField{FieldType: FormFloat, Max: "1"}, "1.5", nil, "Must be at most 1"},
+		{"select option", colour, "R", "R", ""},
+		{"select label isn't a value", colour, "Red", nil, "Not one of the available options"},
+		{"multiselect", toppings, "cheese\nham", []string{"cheese", "ham"}, ""},
+		{"multiselect unknown option", toppings, "cheese\nbacon", nil, "bacon is not one of the available options"},
+		{"multiselect too long for its column", toppings, "cheese\npineapple", nil, "Too many options are selected"},
+		{"date", &FormField{FieldType: FormDate}, "2020-02-29", "2020-02-29", ""},
+		{"date invalid", &FormField{FieldType: FormDate}, "2020-02-30", nil, "Must be a date"},
+		{"date before min", &FormField{FieldType: FormDate, Min: "2020-01-01"}, "2019-12-31", nil, "Must be on or after Jan 1, 2020"},
+		{"date after max", &FormField{FieldType: FormDate, Max: "2020-01-01"}, "2020-01-02", nil, "Must be on or before Jan 1, 2020"},
+		{"timestamp", &FormField{FieldType: FormTimeStamp}, "2020-01-02T03:04",
+			time.Date(2020, 1, 1, 17, 4, 0, 0, time.UTC), ""},
+		{"timestamp with seconds", &FormField{FieldType: FormTimeStamp}, "2020-01-02T03:04:05",
+			time.Date(2020, 1, 1, 17, 4, 5, 0, time.UTC), ""},
+		{"timestamp invalid", &FormField{FieldType: FormTimeStamp}, "yesterday", nil, "Must be a date and time"},
+		// the range is in the user's local time, not UTC
+		{"timestamp before min", &FormField{FieldType: FormTimeStamp, Min: "2020-01-02T09:00"}, "2020-01-02T08:59",
+			nil, "Must be on or after Jan 2, 2020 9:00 AM"},
+		{"timestamp at min", &FormField{FieldType: FormTimeStamp, Min: "2020-01-02T09:00"}, "2020-01-02T09:00",
+			time.Date(2020, 1, 1, 23, 0, 0, 0, time.UTC), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the user is at +10:00
+			got, err := parseFieldValue(tt.field, tt.value, "+10:00")
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !sameValue(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// sameValue compares parsed values, decimals and times by their value
+func sameValue(got interface{}, want interface{}) bool {
+	switch w := want.(type) {
+	case decimal.Decimal:
+		g, ok := got.(decimal.Decimal)
+		return ok && g.Equal(w)
+	case time.Time:
+		g, ok := got.(time.Time)
+		return ok && g.Equal(w)
+	case []string:
+		g, ok := got.([]string)
+		if !ok || len(g) != len(w) {
+			return false
+		}
+		for i := range g {
+			if g[i] != w[i] {
+				return false
+			}
+		}
+		return true
+	}
+	return got == want
+}
+
+func TestValidateSubmission(t *testing.T) {
+	frm := &Form{Fields: []*FormField{
+		{Name: "name", FieldType: FormVarChar, Required: true},
+		{Name: "age", FieldType: FormInteger, Min: "0"},
+		{Name: "employee", FieldType: FormVarChar, Required: true, IsLDAPPopulated: true},
+	}}
+
+	_, err := validateSubmission(context.Background(), frm, map[string]string{"name": "", "age": "-1", "timezone-offset": "0"}, groupRows{})
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("got %v, want a ValidationError", err)
+	}
+	want := FieldErrors{"name": "This field is required", "age": "Must be at least 0"}
+	if len(verr.Fields) != len(want) {
+		t.Errorf("got %v, want %v", verr.Fields, want)
+	}
+	for name, msg := range want {
+		if verr.Fields[name] != msg {
+			t.Errorf("%s: got %q, want %q", name, verr.Fields[name], msg)
+		}
+	}
+
+	parsed, err := validateSubmission(context.Background(), frm, map[string]string{"name": "Ann", "age": "", "timezone-offset": "0"}, groupRows{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed["name"] != "Ann" || parsed["age"] != nil {
+		t.Errorf("got %v", parsed)
+	}
+	if _, exists := parsed["employee"]; exists {
+		t.Error("ldap fields don't come from the user")
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the styles of the cells, indexes into cellXfs of xlsxStyles
+const (
+	xlsxStyleNone = iota
+	xlsxStyleHeader
+	xlsxStyleMoney
+	xlsxStyleDate
+	xlsxStyleTimestamp
+)
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+
+const xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+// xlsxStyles has a bold font for the header, and the number formats of money, dates and timestamps
+const xlsxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="3">
+<numFmt numFmtId="164" formatCode="&quot;$&quot;#,##0.00"/>
+<numFmt numFmtId="165" formatCode="yyyy-mm-dd"/>
+<numFmt numFmtId="166" formatCode="yyyy-mm-dd hh:mm"/>
+</numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="5">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="166" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+</cellXfs>
+</styleSheet>`
+
+// xlsxEpoch is day zero of Excel's date serial numbers
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// xlsxSheet writes a workbook with a single sheet, a row at a time so large exports can be streamed
+type xlsxSheet struct {
+	zw    *zip.Writer
+	sheet io.Writer
+}
+
+// newXLSXSheet starts the workbook, with the sheet's title and the widths of its columns in characters
+func newXLSXSheet(w io.Writer, title string, widths []float64) (*xlsxSheet, error) {
+	zw := zip.NewWriter(w)
+	parts := []struct{ name, content string }{
+		{"[Content_Types].xml", xlsxContentTypes},
+		{"_rels/.rels", xlsxRels},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels},
+		{"xl/workbook.xml", fmt.Sprintf(xlsxWorkbook, xlsxEscape(xlsxSheetName(title)))},
+		{"xl/styles.xml", xlsxStyles},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to create "+part.name)
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, errors.Wrap(err, "unable to write "+part.name)
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create the worksheet")
+	}
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	b.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	// keep the header row in view when scrolling
+	b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>`)
+	b.WriteString("<cols>")
+	for i, width := range widths {
+		fmt.Fprintf(&b, `<col min="%d" max="%d" width="%.1f" customWidth="1"/>`, i+1, i+1, width)
+	}
+	b.WriteString("</cols><sheetData>")
+	if _, err := io.WriteString(sheet, b.String()); err != nil {
+		return nil, errors.Wrap(err, "unable to write the worksheet")
+	}
+	return &xlsxSheet{zw: zw, sheet: sheet}, nil
+}
+
+// writeHeader adds a row of bold text
+func (s *xlsxSheet) writeHeader(headers []string) error {
+	var b strings.Builder
+	b.WriteString("<row>")
+	for _, h := range headers {
+		fmt.Fprintf(&b, `<c t="inlineStr" s="%d"><is><t xml:space="preserve">%s</t></is></c>`, xlsxStyleHeader, xlsxEscape(h))
+	}
+	b.WriteString("</row>")
+	_, err := io.WriteString(s.sheet, b.String())
+	return err
+}
+
+// writeRow adds a row of form values, each written as the native cell type for its field type
+func (s *xlsxSheet) writeRow(types []FormFieldType, values []string) error {
+	var b strings.Builder
+	b.WriteString("<row>")
+	for i, val := range values {
+		b.WriteString(xlsxCell(types[i], val))
+	}
+	b.WriteString("</row>")
+	_, err := io.WriteString(s.sheet, b.String())
+	return err
+}
+
+// flush sends what has been written so far
+func (s *xlsxSheet) flush() error {
+	return s.zw.Flush()
+}
+
+// close finishes the sheet and the workbook
+func (s *xlsxSheet) close() error {
+	if _, err := io.WriteString(s.sheet, "</sheetData></worksheet>"); err != nil {
+		return err
+	}
+	return s.zw.Close()
+}
+
+// xlsxCell is the cell for a form value. Values that can't be read as their type, e.g. an integer
+// column holding something else in sqlite, are written as text. Text is an inline string, which
+// Excel never evaluates as a formula, so unlike the csv export it doesn't need escaping.
+func xlsxCell(fieldType FormFieldType, val string) string {
+	if val == "" && fieldType != FormBoolean {
+		return "<c/>"
+	}
+	switch fieldType {
+	case FormInteger, FormDecimal, FormFloat:
+		if _, err := strconv.ParseFloat(val, 64); err == nil {
+			return "<c><v>" + val + "</v></c>"
+		}
+	case FormMoney:
+		if _, err := strconv.ParseFloat(val, 64); err == nil {
+			return fmt.Sprintf(`<c s="%d"><v>%s</v></c>`, xlsxStyleMoney, val)
+		}
+	case FormDate:
+		if t, err := time.Parse(DateLocal, val); err == nil {
+			return fmt.Sprintf(`<c s="%d"><v>%s</v></c>`, xlsxStyleDate, xlsxSerial(t))
+		}
+	case FormTimeStamp:
+		if t, err := time.Parse(DateTimeLocal, val); err == nil {
+			return fmt.Sprintf(`<c s="%d"><v>%s</v></c>`, xlsxStyleTimestamp, xlsxSerial(t))
+		}
+	case FormBoolean:
+		if val == "1" {
+			return `<c t="b"><v>1</v></c>`
+		}
+		return `<c t="b"><v>0</v></c>`
+	}
+	return `<c t="inlineStr"><is><t xml:space="preserve">` + xlsxEscape(val) + "</t></is></c>"
+}
+
+// xlsxSerial is the date as the number of days since Excel's epoch
+func xlsxSerial(t time.Time) string {
+	days := float64(t.Sub(xlsxEpoch)) / float64(24*time.Hour)
+	return strconv.FormatFloat(days, 'f', -1, 64)
+}
+
+// xlsxWidth is the width of a column in characters, wide enough for the header and typical values
+func xlsxWidth(fieldType FormFieldType, header string, maxLength int) float64 {
+	width := 10
+	switch fieldType {
+	case FormDate:
+		width = 12
+	case FormTimeStamp:
+		width = 17
+	case FormInteger, FormDecimal, FormFloat, FormMoney:
+		width = 14
+	case FormBoolean:
+		width = 8
+	case FormText, FormMultiSelect, FormGroup:
+		width = 50
+	case FormVarChar:
+		width = 30
+		if maxLength > 0 && maxLength < width {
+			width = maxLength
+		}
+	}
+	if len(header)+2 > width {
+		width = len(header) + 2
+	}
+	return float64(width)
+}
+
+// xlsxSheetName removes the characters Excel doesn't allow in a sheet name, and shortens it to the
+// 31 characters allowed
+func xlsxSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	if strings.TrimSpace(name) == "" {
+		return "Sheet1"
+	}
+	return name
+}
+
+// xlsxEscape escapes text for the sheet's xml, replacing any characters xml can't hold
+func xlsxEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}